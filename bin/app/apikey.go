@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cunkz/goyummy/bin/helpers/auth"
+)
+
+// runAPIKey prints a new api key and the hash to put in the recipe or in
+// the key store. The key itself is never stored.
+func runAPIKey(args []string) int {
+	fs := flag.NewFlagSet("apikey", flag.ExitOnError)
+	owner := fs.String("owner", "my-service", "owner written in the recipe snippet")
+	_ = fs.Parse(args)
+
+	key, hash, err := auth.NewAPIKey()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("key:  %s\nhash: %s\n\n", key, hash)
+	fmt.Println("Give the key to the client, keep only the hash:")
+	fmt.Printf("\n    apikey_keys:\n      - owner: %s\n        hash: %s\n", *owner, hash)
+	return 0
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cunkz/goyummy/bin/config"
+)
+
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: goyummy config print [-recipe file] [-resolved] [-json]")
+		return 2
+	}
+	fs := flag.NewFlagSet("config print", flag.ExitOnError)
+	recipe := fs.String("recipe", "", "recipe file (default: recipe.yaml, recipe.yml or recipe.json)")
+	resolved := fs.Bool("resolved", false, "the recipe as served: references expanded, CONFIG_YAML/CONFIG_JSON and environment overrides applied")
+	asJSON := fs.Bool("json", false, "print JSON, with -resolved")
+	_ = fs.Parse(args[1:])
+
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	// as written, after includes, environment and templates
+	if !*resolved {
+		if *asJSON {
+			fmt.Fprintln(os.Stderr, "-json needs -resolved")
+			return 2
+		}
+		root, err := config.Compose(*recipe)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if root == nil {
+			return 0 // empty recipe
+		}
+		config.RedactNode(root)
+		blockStyle(root)
+		return printYAML(root)
+	}
+
+	// not validated, an invalid recipe is what this is used to debug
+	cfg, err := config.LoadAuto(*recipe)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	config.Redact(cfg)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+	return printYAML(cfg)
+}
+
+// blockStyle prints every list and mapping as a block, included JSON files
+// would print as flow otherwise
+func blockStyle(n *yaml.Node) {
+	n.Style &^= yaml.FlowStyle
+	for i, c := range n.Content {
+		if n.Kind == yaml.MappingNode && i%2 == 0 {
+			c.Style = 0 // keys need no quotes
+		}
+		blockStyle(c)
+	}
+}
+
+func printYAML(v any) int {
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -1,54 +1,56 @@
 package main
 
 import (
-	"strconv"
-
-	"github.com/gofiber/fiber/v2"
-	"github.com/rs/zerolog/log"
-
-	"github.com/cunkz/goyummy/bin/config"
-	"github.com/cunkz/goyummy/bin/helpers/db"
-	"github.com/cunkz/goyummy/bin/helpers/utils"
-	"github.com/cunkz/goyummy/bin/middleware"
-
-	"github.com/cunkz/goyummy/bin/modules"
+	"fmt"
+	"os"
+	"strings"
 )
 
-func main() {
-	// Initalize Config
-	cfg, _ := config.LoadAuto()
-
-	// Initialize logger
-	utils.InitLogger(cfg)
-	log.Info().Msg("Init Logger")
+const usage = `GoYummy — Tasty service builder Go + Fiber
 
-	// Create Fiber instance
-	app := fiber.New()
+Usage:
+  goyummy <command> [flags]
 
-	// Add request logging middleware
-	app.Use(middleware.RequestLogger())
+Commands:
+  serve      start the service (default when no command is given)
+  validate   lint a recipe without connecting to databases
+  routes     print the route table generated by a recipe
+  init       scaffold a starter recipe
+  apikey     generate an api key and its hash
+  config     print the recipe, "config print -resolved" as served with secrets redacted
+  schema     print the JSON Schema of recipes
 
-	if err := db.InitDatabases(cfg); err != nil {
-		log.Error().Err(err).Msg("error init databases")
-	}
+Run "goyummy <command> -h" for the flags of a command.
+`
 
-	// Add Ready and Health check Route
-	utils.RegisterHealthCheckRoutes(app)
-
-	// Register routes and controllers for each module
-	modules.RegisterModules(app, cfg)
-
-	// Network check
-	ln := utils.NetCheck(cfg.Server.Host, strconv.Itoa(cfg.Server.Port))
+func main() {
+	args := os.Args[1:]
 
-	// Start Fiber
-	go func() {
-		log.Info().Msgf("🚀 Server is ready at http://%s:%s", cfg.Server.Host, strconv.Itoa(cfg.Server.Port))
-		if err := app.Listener(ln); err != nil {
-			log.Error().Err(err).Msg("server stopped")
-		}
-	}()
+	// keep "go run ./bin/app" and "goyummy --recipe x" serving
+	cmd := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
 
-	// Graceful shutdown
-	utils.GracefulShutdown(app)
+	switch cmd {
+	case "serve":
+		os.Exit(runServe(args))
+	case "validate":
+		os.Exit(runValidate(args))
+	case "routes":
+		os.Exit(runRoutes(args))
+	case "init":
+		os.Exit(runInit(args))
+	case "apikey":
+		os.Exit(runAPIKey(args))
+	case "config":
+		os.Exit(runConfig(args))
+	case "schema":
+		os.Exit(runSchema(args))
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
 }
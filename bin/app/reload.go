@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"github.com/cunkz/goyummy/bin/config"
+	"github.com/cunkz/goyummy/bin/helpers/db"
+	"github.com/cunkz/goyummy/bin/modules"
+)
+
+const (
+	// The recipe file is checked for changes this often
+	recipeCheckEvery = 2 * time.Second
+	// Requests of a replaced recipe get this long to finish before its
+	// connections are closed
+	drainTimeout = 30 * time.Second
+	// Connections of a new recipe must answer within this
+	pingTimeout = 10 * time.Second
+)
+
+// -------------------------------------
+// GENERATION
+// -------------------------------------
+
+// generation is the router and the connections built from one recipe
+type generation struct {
+	cfg     *config.AppConfig
+	reg     *db.Registry
+	handler fasthttp.RequestHandler
+
+	mu      sync.Mutex
+	active  int
+	retired bool
+	done    chan struct{}
+}
+
+// newGeneration connects the databases of cfg, migrates them and registers
+// the modules on a router of their own. A strict build fails on any
+// database error, the first build only logs connection errors as serve
+// always did. Nothing is left open on error.
+func newGeneration(cfg *config.AppConfig, strict bool) (*generation, error) {
+	reg, err := db.InitDatabases(cfg)
+	if err != nil {
+		if strict {
+			reg.Close(context.Background())
+			return nil, fmt.Errorf("error init databases: %w", err)
+		}
+		log.Error().Err(err).Msg("error init databases")
+	}
+	if strict {
+		ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+		err := reg.Ping(ctx)
+		cancel()
+		if err != nil {
+			reg.Close(context.Background())
+			return nil, fmt.Errorf("error init databases: %w", err)
+		}
+	}
+
+	// Create or verify tables from the recipe
+	if err := db.Migrate(cfg, reg); err != nil {
+		reg.Close(context.Background())
+		return nil, fmt.Errorf("error migrate databases: %w", err)
+	}
+
+	// Register routes and controllers for each module
+	app := fiber.New()
+	if err := modules.RegisterModules(app, cfg, reg); err != nil {
+		reg.Close(context.Background())
+		return nil, fmt.Errorf("error register modules: %w", err)
+	}
+
+	return &generation{cfg: cfg, reg: reg, handler: app.Handler(), done: make(chan struct{})}, nil
+}
+
+// acquire counts a request, false once the generation was replaced
+func (g *generation) acquire() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.retired {
+		return false
+	}
+	g.active++
+	return true
+}
+
+func (g *generation) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active--
+	if g.retired && g.active == 0 {
+		close(g.done)
+	}
+}
+
+// retire waits for the requests still running, then closes the connections
+func (g *generation) retire() {
+	g.mu.Lock()
+	g.retired = true
+	if g.active == 0 {
+		close(g.done)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-g.done:
+	case <-time.After(drainTimeout):
+		log.Warn().Msgf("Requests of the previous recipe still running after %s, closing its connections", drainTimeout)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	g.reg.Close(ctx)
+}
+
+// -------------------------------------
+// ROUTER
+// -------------------------------------
+
+// router serves every request with the current generation. A reload
+// builds a new one next to it and swaps it in, requests already running
+// finish on the generation they started with.
+type router struct {
+	path    string
+	current atomic.Pointer[generation]
+	// one reload at a time
+	mu sync.Mutex
+}
+
+func newRouter(path string, g *generation) *router {
+	r := &router{path: config.RecipePath(path)}
+	r.current.Store(g)
+	return r
+}
+
+func (r *router) handle(c *fiber.Ctx) error {
+	for {
+		g := r.current.Load()
+		if !g.acquire() {
+			continue // swapped meanwhile, the next one is already stored
+		}
+		defer g.release()
+		g.handler(c.Context())
+		return nil
+	}
+}
+
+// reload builds a generation from the recipe and swaps it in. On any error
+// the running one is kept.
+func (r *router) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := loadRecipe(r.path)
+	if err != nil {
+		return err
+	}
+	old := r.current.Load()
+	if !reflect.DeepEqual(old.cfg.Server, cfg.Server) || !reflect.DeepEqual(old.cfg.Logging, cfg.Logging) {
+		log.Warn().Msg("Changes to server and logging need a restart, they are not applied")
+	}
+
+	g, err := newGeneration(cfg, true)
+	if err != nil {
+		return err
+	}
+	r.current.Store(g)
+	go old.retire()
+	return nil
+}
+
+func (r *router) tryReload(reason string) {
+	log.Info().Msgf("Reload recipe: %s", reason)
+	if err := r.reload(); err != nil {
+		log.Error().Err(err).Msg("Reload failed, keeping the running recipe")
+		return
+	}
+	log.Info().Msg("Recipe reloaded")
+}
+
+// watch reloads on SIGHUP, and when a file of the recipe changes if poll
+// is set. Files added to an include pattern are only seen on SIGHUP.
+func (r *router) watch(poll bool) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	last := r.stamp()
+	if poll && r.path != "" {
+		tick = time.NewTicker(recipeCheckEvery).C
+		log.Info().Msgf("Watching %s for changes", strings.Join(r.files(), ", "))
+	}
+
+	for {
+		select {
+		case <-hup:
+			r.tryReload("SIGHUP")
+		case <-tick:
+			if r.stamp() == last {
+				continue
+			}
+			r.tryReload("recipe changed")
+		}
+		last = r.stamp()
+	}
+}
+
+// files are those of the running recipe and its includes
+func (r *router) files() []string {
+	return r.current.Load().cfg.Files()
+}
+
+// stamp changes when a file of the recipe is written, removed or replaced
+func (r *router) stamp() string {
+	var b strings.Builder
+	for _, f := range append(r.files(), r.path) {
+		if st, err := os.Stat(f); err == nil {
+			fmt.Fprintf(&b, "%s %d %d\n", f, st.ModTime().UnixNano(), st.Size())
+		}
+	}
+	return b.String()
+}
+
+// close closes the connections of the current generation on shutdown
+func (r *router) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	r.current.Load().reg.Close(ctx)
+}
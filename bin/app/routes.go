@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cunkz/goyummy/bin/helpers/utils"
+	"github.com/cunkz/goyummy/bin/modules"
+)
+
+func runRoutes(args []string) int {
+	fs := flag.NewFlagSet("routes", flag.ExitOnError)
+	recipe := fs.String("recipe", "", "recipe file (default: recipe.yaml, recipe.yml or recipe.json)")
+	asJSON := fs.Bool("json", false, "print routes as JSON")
+	_ = fs.Parse(args)
+
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	cfg, err := loadRecipe(*recipe)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// health checks and the recipe schema are always registered and public
+	routes := []modules.Route{
+		{Method: "GET", Path: "/healthz"},
+		{Method: "GET", Path: "/readyz"},
+		{Method: "GET", Path: utils.SchemaPath},
+	}
+	routes = append(routes, modules.Routes(cfg)...)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(routes); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tPATH\tMODULE\tOPERATION\tENGINE\tAUTH")
+	for _, r := range routes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Method, r.Path, orDash(r.Module), orDash(r.Operation), orDash(r.Engine), authLabel(r))
+	}
+	_ = w.Flush()
+	return 0
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func authLabel(r modules.Route) string {
+	if r.Auth == "" {
+		return "public"
+	}
+	label := r.Auth
+	if r.Require != nil {
+		if len(r.Require.Roles) > 0 {
+			label += " roles=" + strings.Join(r.Require.Roles, "|")
+		}
+		if len(r.Require.Scope) > 0 {
+			label += " scope=" + strings.Join(r.Require.Scope, ",")
+		}
+	}
+	return label
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"github.com/cunkz/goyummy/bin/config"
+	"github.com/cunkz/goyummy/bin/helpers/utils"
+	"github.com/cunkz/goyummy/bin/middleware"
+)
+
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	recipe := fs.String("recipe", "", "recipe file (default: recipe.yaml, recipe.yml or recipe.json)")
+	watch := fs.Bool("watch", false, "also reload the recipe when its file changes, SIGHUP always reloads")
+	_ = fs.Parse(args)
+
+	// Initalize Config
+	cfg, err := loadRecipe(*recipe)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Initialize logger
+	utils.InitLogger(cfg)
+	log.Info().Msg("Init Logger")
+
+	// Create Fiber instance
+	app := fiber.New()
+
+	// Add request logging middleware
+	app.Use(middleware.RequestLogger())
+
+	// Add Ready and Health check Route
+	utils.RegisterHealthCheckRoutes(app)
+
+	// JSON Schema of the recipe format
+	utils.RegisterSchemaRoute(app)
+
+	// Databases and routes of the recipe, swapped as a whole on reload
+	g, err := newGeneration(cfg, false)
+	if err != nil {
+		log.Error().Err(err).Msg("error start recipe")
+		return 1
+	}
+	router := newRouter(*recipe, g)
+	app.Use(router.handle)
+	go router.watch(*watch)
+
+	// Network check
+	ln := utils.NetCheck(cfg.Server.Host, strconv.Itoa(cfg.Server.Port))
+
+	// Start Fiber
+	go func() {
+		log.Info().Msgf("🚀 Server is ready at http://%s:%s", cfg.Server.Host, strconv.Itoa(cfg.Server.Port))
+		if err := app.Listener(ln); err != nil {
+			log.Error().Err(err).Msg("server stopped")
+		}
+	}()
+
+	// Graceful shutdown
+	utils.GracefulShutdown(app)
+	router.close()
+	return 0
+}
+
+// loadRecipe loads and validates a recipe, path may be empty to auto-detect
+func loadRecipe(path string) (*config.AppConfig, error) {
+	cfg, err := config.LoadAuto(path)
+	if err != nil {
+		return nil, fmt.Errorf("error load recipe: %w", err)
+	}
+
+	// Validate Config, abort with every problem found
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
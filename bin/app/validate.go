@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cunkz/goyummy/bin/helpers/auth"
+	"github.com/cunkz/goyummy/bin/modules"
+)
+
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	recipe := fs.String("recipe", "", "recipe file (default: recipe.yaml, recipe.yml or recipe.json)")
+	_ = fs.Parse(args)
+
+	// only problems are interesting here
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	cfg, err := loadRecipe(*recipe)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// keys are parsed the same way serve does, databases and JWKS are not
+	// touched
+	if err := auth.CheckAuths(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, "recipe is invalid:", err)
+		return 1
+	}
+
+	fmt.Printf("recipe is valid: %d database(s), %d auth(s), %d module(s), %d route(s)\n",
+		len(cfg.Databases), len(cfg.Auths), len(cfg.Modules), len(modules.Routes(cfg)))
+	return 0
+}
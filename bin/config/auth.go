@@ -0,0 +1,514 @@
+package config
+
+import (
+	"encoding/json"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Auth types
+const (
+	AuthBasic     = "basic"
+	AuthJWT       = "jwt"
+	AuthAPIKey    = "apikey"
+	AuthIP        = "ip"
+	AuthComposite = "composite"
+)
+
+var AuthTypes = []string{AuthBasic, AuthJWT, AuthAPIKey, AuthIP, AuthComposite}
+
+type Auth struct {
+	Name string `yaml:"name" json:"name"`
+	Type string `yaml:"type" json:"type"`
+
+	JWTPubKey64   string `yaml:"jwt_pubkey64,omitempty" json:"jwt_pubkey64,omitempty"`
+	JWTPrivKey64  string `yaml:"jwt_privkey64,omitempty" json:"jwt_privkey64,omitempty" secret:"true"`
+	BasicUsername string `yaml:"basic_username,omitempty" json:"basic_username,omitempty"`
+	BasicPassword string `yaml:"basic_password,omitempty" json:"basic_password,omitempty" secret:"true"`
+
+	// basic: more users with hashed passwords, and an htpasswd file read
+	// again when it changes. basic_password may be a hash too.
+	BasicUsers    []BasicUser `yaml:"basic_users,omitempty" json:"basic_users,omitempty"`
+	BasicHtpasswd string      `yaml:"basic_htpasswd,omitempty" json:"basic_htpasswd,omitempty"`
+	BasicRealm    string      `yaml:"basic_realm,omitempty" json:"basic_realm,omitempty"`
+
+	// jwt verification. Without jwt_alg the algorithms follow the keys:
+	// RS256 or ES256/384/512 for jwt_pubkey64, HS256 for jwt_secret and
+	// every asymmetric algorithm for jwks_url.
+	JWTAlg      StringList `yaml:"jwt_alg,omitempty" json:"jwt_alg,omitempty"`
+	JWTSecret   string     `yaml:"jwt_secret,omitempty" json:"jwt_secret,omitempty" secret:"true"`
+	JWTKeys     []JWTKey   `yaml:"jwt_keys,omitempty" json:"jwt_keys,omitempty"`
+	JWKSURL     string     `yaml:"jwks_url,omitempty" json:"jwks_url,omitempty"`
+	JWKSRefresh string     `yaml:"jwks_refresh,omitempty" json:"jwks_refresh,omitempty"`
+	JWTIssuer   string     `yaml:"jwt_issuer,omitempty" json:"jwt_issuer,omitempty"`
+	JWTAudience StringList `yaml:"jwt_audience,omitempty" json:"jwt_audience,omitempty"`
+	JWTLeeway   string     `yaml:"jwt_leeway,omitempty" json:"jwt_leeway,omitempty"`
+
+	// JWTIssue adds login, refresh and revoke endpoints signing tokens
+	// with jwt_privkey64 or jwt_secret
+	JWTIssue *TokenIssue `yaml:"jwt_issue,omitempty" json:"jwt_issue,omitempty"`
+
+	// apikey: the key is read from the header, then from the query param
+	// when one is set. Keys are listed in the recipe, in a table, or both.
+	APIKeyHeader string       `yaml:"apikey_header,omitempty" json:"apikey_header,omitempty"`
+	APIKeyQuery  string       `yaml:"apikey_query,omitempty" json:"apikey_query,omitempty"`
+	APIKeys      []APIKey     `yaml:"apikey_keys,omitempty" json:"apikey_keys,omitempty"`
+	APIKeyStore  *APIKeyStore `yaml:"apikey_store,omitempty" json:"apikey_store,omitempty"`
+
+	// ip: addresses or CIDRs allowed. Behind ip_proxies the client is read
+	// from X-Forwarded-For.
+	IPAllow   StringList `yaml:"ip_allow,omitempty" json:"ip_allow,omitempty"`
+	IPProxies StringList `yaml:"ip_proxies,omitempty" json:"ip_proxies,omitempty"`
+
+	// composite: other auths, one of any must accept the request or every
+	// one of all
+	Any StringList `yaml:"any,omitempty" json:"any,omitempty"`
+	All StringList `yaml:"all,omitempty" json:"all,omitempty"`
+
+	// Claim paths read from jwt tokens, dots walk into nested objects
+	RolesClaim  string `yaml:"roles_claim,omitempty" json:"roles_claim,omitempty"`
+	ScopesClaim string `yaml:"scopes_claim,omitempty" json:"scopes_claim,omitempty"`
+}
+
+// ParsePrefix reads an ip_allow entry, a single address is a /32 or /128
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// BasicUser is a user of a basic auth, the password is a bcrypt or
+// argon2id hash
+type BasicUser struct {
+	Username     string     `yaml:"username" json:"username"`
+	PasswordHash string     `yaml:"password_hash" json:"password_hash" secret:"true"`
+	Roles        StringList `yaml:"roles,omitempty" json:"roles,omitempty"`
+}
+
+// DefaultBasicRealm is sent in WWW-Authenticate when basic_realm is not set
+const DefaultBasicRealm = "Restricted"
+
+// JWTKey is a verification key picked by the kid header of a token
+type JWTKey struct {
+	KID      string `yaml:"kid" json:"kid"`
+	PubKey64 string `yaml:"pubkey64,omitempty" json:"pubkey64,omitempty"`
+	Secret   string `yaml:"secret,omitempty" json:"secret,omitempty" secret:"true"`
+}
+
+// TokenIssue configures the token endpoints of a jwt auth. Callers log in
+// with the credentials of a users module or of a basic auth.
+type TokenIssue struct {
+	// Path of the endpoints, default /api/<auth name>/v1
+	Path       string         `yaml:"path,omitempty" json:"path,omitempty"`
+	Users      *TokenUsers    `yaml:"users,omitempty" json:"users,omitempty"`
+	Basic      string         `yaml:"basic,omitempty" json:"basic,omitempty"`
+	AccessTTL  string         `yaml:"access_ttl,omitempty" json:"access_ttl,omitempty"`
+	RefreshTTL string         `yaml:"refresh_ttl,omitempty" json:"refresh_ttl,omitempty"`
+	KID        string         `yaml:"kid,omitempty" json:"kid,omitempty"`
+	Claims     map[string]any `yaml:"claims,omitempty" json:"claims,omitempty"`
+	Denylist   TokenDenylist  `yaml:"denylist" json:"denylist"`
+}
+
+// BasePath returns the path of the endpoints, /api/<auth name>/v1 by default
+func (t TokenIssue) BasePath(authName string) string {
+	if t.Path != "" {
+		return strings.TrimSuffix(t.Path, "/")
+	}
+	return "/api/" + slugify(authName) + "/v1"
+}
+
+// TokenUsers reads accounts from a module with a hashed password field
+type TokenUsers struct {
+	Module        string `yaml:"module" json:"module"`
+	UsernameField string `yaml:"username_field,omitempty" json:"username_field,omitempty"`
+	PasswordField string `yaml:"password_field,omitempty" json:"password_field,omitempty"`
+	// Claims copies fields of the user into the token, claim: field
+	Claims map[string]string `yaml:"claims,omitempty" json:"claims,omitempty"`
+}
+
+// UsernameFieldName returns the configured username field or its default
+func (u TokenUsers) UsernameFieldName() string {
+	if u.UsernameField != "" {
+		return u.UsernameField
+	}
+	return DefaultUsernameField
+}
+
+// PasswordFieldName returns the configured password field or its default
+func (u TokenUsers) PasswordFieldName() string {
+	if u.PasswordField != "" {
+		return u.PasswordField
+	}
+	return DefaultPasswordField
+}
+
+// TokenDenylist is the table (or collection) of revoked token ids
+type TokenDenylist struct {
+	Database string `yaml:"database" json:"database"`
+	Table    string `yaml:"table" json:"table"`
+}
+
+// Token endpoint defaults
+const (
+	DefaultAccessTTL     = 15 * time.Minute
+	DefaultRefreshTTL    = 7 * 24 * time.Hour
+	DefaultUsernameField = "username"
+	DefaultPasswordField = "password"
+)
+
+// DenylistFields are the columns of a token denylist besides the system
+// fields, the id is the jti of the revoked token
+var DenylistFields = []Field{
+	{Name: "expires_at", Type: FieldTimestamp},
+}
+
+// Signing algorithms accepted in jwt_alg
+var JWTAlgs = []string{
+	"HS256", "HS384", "HS512",
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+// DefaultJWKSRefresh is how often jwks_url is fetched again
+const DefaultJWKSRefresh = time.Hour
+
+// APIKey is a key of an apikey auth. Only the sha256 hex of the key is
+// kept, see "goyummy apikey" to make one.
+type APIKey struct {
+	Owner     string     `yaml:"owner" json:"owner"`
+	Hash      string     `yaml:"hash" json:"hash"`
+	Roles     StringList `yaml:"roles,omitempty" json:"roles,omitempty"`
+	Scopes    StringList `yaml:"scopes,omitempty" json:"scopes,omitempty"`
+	ExpiresAt *time.Time `yaml:"expires_at,omitempty" json:"expires_at,omitempty"`
+	Revoked   bool       `yaml:"revoked,omitempty" json:"revoked,omitempty"`
+}
+
+// APIKeyStore is a table (or collection) of keys, read on every request
+// so keys can be added, expired and revoked without a restart
+type APIKeyStore struct {
+	Database string `yaml:"database" json:"database"`
+	Table    string `yaml:"table" json:"table"`
+}
+
+// DefaultAPIKeyHeader carries the key when apikey_header is not set
+const DefaultAPIKeyHeader = "X-API-Key"
+
+// APIKeyFields are the columns of an apikey store besides the system
+// fields. roles and scopes are space separated.
+var APIKeyFields = []Field{
+	{Name: "key_hash", Type: FieldString, Validate: FieldRules{MaxLength: intPtr(64)}},
+	{Name: "owner", Type: FieldString},
+	{Name: "roles", Type: FieldString, Nullable: true},
+	{Name: "scopes", Type: FieldString, Nullable: true},
+	{Name: "expires_at", Type: FieldTimestamp, Nullable: true},
+	{Name: "revoked", Type: FieldBool},
+}
+
+func intPtr(n int) *int { return &n }
+
+// APIKeyHeaderName returns the configured header or its default
+func (a Auth) APIKeyHeaderName() string {
+	if a.APIKeyHeader != "" {
+		return a.APIKeyHeader
+	}
+	return DefaultAPIKeyHeader
+}
+
+// Default claim paths of jwt auths
+const (
+	DefaultRolesClaim  = "roles"
+	DefaultScopesClaim = "scope"
+)
+
+// RolesClaimPath returns the configured roles claim or its default
+func (a Auth) RolesClaimPath() string {
+	if a.RolesClaim != "" {
+		return a.RolesClaim
+	}
+	return DefaultRolesClaim
+}
+
+// ScopesClaimPath returns the configured scopes claim or its default
+func (a Auth) ScopesClaimPath() string {
+	if a.ScopesClaim != "" {
+		return a.ScopesClaim
+	}
+	return DefaultScopesClaim
+}
+
+// AuthPublic marks an operation without auth in a per-operation auth map
+const AuthPublic = "public"
+
+// ModuleAuth is the auth of a module. In the recipe it is either one auth
+// name for every operation, or a map of operation to auth name with an
+// optional "default" entry:
+//
+//	auth:
+//	  default: auth-jwt
+//	  read_list: public
+//	  read_single: public
+type ModuleAuth struct {
+	Default    string
+	Operations map[string]string
+}
+
+// For returns the auth name of an operation, "" when it is public
+func (a ModuleAuth) For(op string) string {
+	name, ok := a.Operations[op]
+	if !ok {
+		name = a.Default
+	}
+	if name == AuthPublic {
+		return ""
+	}
+	return name
+}
+
+// Names returns every auth name referenced, keyed by operation or "default"
+func (a ModuleAuth) Names() map[string]string {
+	names := map[string]string{}
+	if a.Default != "" && a.Default != AuthPublic {
+		names["default"] = a.Default
+	}
+	for op, name := range a.Operations {
+		if name != "" && name != AuthPublic {
+			names[op] = name
+		}
+	}
+	return names
+}
+
+func (a ModuleAuth) IsZero() bool {
+	return a.Default == "" && len(a.Operations) == 0
+}
+
+func (a *ModuleAuth) fromMap(m map[string]string) {
+	*a = ModuleAuth{Default: m["default"]}
+	for op, name := range m {
+		if op == "default" {
+			continue
+		}
+		if a.Operations == nil {
+			a.Operations = map[string]string{}
+		}
+		a.Operations[op] = name
+	}
+}
+
+func (a ModuleAuth) toMap() map[string]string {
+	m := map[string]string{}
+	if a.Default != "" {
+		m["default"] = a.Default
+	}
+	for op, name := range a.Operations {
+		m[op] = name
+	}
+	return m
+}
+
+func (a *ModuleAuth) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*a = ModuleAuth{Default: value.Value}
+		return nil
+	}
+	m := map[string]string{}
+	if err := value.Decode(&m); err != nil {
+		return err
+	}
+	a.fromMap(m)
+	return nil
+}
+
+func (a *ModuleAuth) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*a = ModuleAuth{Default: name}
+		return nil
+	}
+	m := map[string]string{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	a.fromMap(m)
+	return nil
+}
+
+func (a ModuleAuth) MarshalYAML() (any, error) {
+	if len(a.Operations) == 0 {
+		return a.Default, nil
+	}
+	return a.toMap(), nil
+}
+
+func (a ModuleAuth) MarshalJSON() ([]byte, error) {
+	if len(a.Operations) == 0 {
+		return json.Marshal(a.Default)
+	}
+	return json.Marshal(a.toMap())
+}
+
+// -------------------------------------
+// Requirements: roles and scopes
+// -------------------------------------
+
+// StringList is a list of strings, a single string is a list of one
+type StringList []string
+
+func (l *StringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = StringList{value.Value}
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+func (l *StringList) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = StringList{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// Requirement is checked against the claims of a valid token. The caller
+// needs one of Roles and every one of Scope.
+type Requirement struct {
+	Roles StringList `yaml:"roles,omitempty" json:"roles,omitempty"`
+	Scope StringList `yaml:"scope,omitempty" json:"scope,omitempty"`
+}
+
+func (r Requirement) IsZero() bool {
+	return len(r.Roles) == 0 && len(r.Scope) == 0
+}
+
+// ModuleRequire is the requirement of a module. In the recipe it is either
+// one requirement for every operation, or a map of operation to
+// requirement with an optional "default" entry. Public operations skip
+// the default one:
+//
+//	require:
+//	  default: {roles: [admin, editor]}
+//	  create: {scope: "catalog:write"}
+//	  read_list: {}
+type ModuleRequire struct {
+	Default    Requirement
+	Operations map[string]Requirement
+}
+
+// For returns the requirement of an operation
+func (r ModuleRequire) For(op string) Requirement {
+	if req, ok := r.Operations[op]; ok {
+		return req
+	}
+	return r.Default
+}
+
+func (r ModuleRequire) IsZero() bool {
+	return r.Default.IsZero() && len(r.Operations) == 0
+}
+
+// isOperationMap tells a per-operation map from a single requirement
+func isOperationMap(keys []string) bool {
+	return slices.ContainsFunc(keys, func(k string) bool {
+		return k == "default" || slices.Contains(Operations, k)
+	})
+}
+
+func (r *ModuleRequire) fromMap(m map[string]Requirement) {
+	*r = ModuleRequire{Default: m["default"]}
+	for op, req := range m {
+		if op == "default" {
+			continue
+		}
+		if r.Operations == nil {
+			r.Operations = map[string]Requirement{}
+		}
+		r.Operations[op] = req
+	}
+}
+
+func (r ModuleRequire) toMap() map[string]Requirement {
+	m := map[string]Requirement{}
+	if !r.Default.IsZero() {
+		m["default"] = r.Default
+	}
+	for op, req := range r.Operations {
+		m[op] = req
+	}
+	return m
+}
+
+func (r *ModuleRequire) UnmarshalYAML(value *yaml.Node) error {
+	var keys []string
+	for i := 0; i+1 < len(value.Content) && value.Kind == yaml.MappingNode; i += 2 {
+		keys = append(keys, value.Content[i].Value)
+	}
+	if !isOperationMap(keys) {
+		*r = ModuleRequire{}
+		return value.Decode(&r.Default)
+	}
+	m := map[string]Requirement{}
+	if err := value.Decode(&m); err != nil {
+		return err
+	}
+	r.fromMap(m)
+	return nil
+}
+
+func (r *ModuleRequire) UnmarshalJSON(data []byte) error {
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	if !isOperationMap(keys) {
+		*r = ModuleRequire{}
+		return json.Unmarshal(data, &r.Default)
+	}
+	m := map[string]Requirement{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	r.fromMap(m)
+	return nil
+}
+
+func (r ModuleRequire) MarshalYAML() (any, error) {
+	if len(r.Operations) == 0 {
+		return r.Default, nil
+	}
+	return r.toMap(), nil
+}
+
+func (r ModuleRequire) MarshalJSON() ([]byte, error) {
+	if len(r.Operations) == 0 {
+		return json.Marshal(r.Default)
+	}
+	return json.Marshal(r.toMap())
+}
@@ -0,0 +1,390 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// -------------------------------------
+// Composition: includes, environments and templates
+// -------------------------------------
+
+// Lists of these keys merge entry by entry on name, other lists are
+// replaced. A plain string in them is a name, as in the shorthand fields.
+var namedLists = map[string]bool{
+	"databases": true,
+	"auths":     true,
+	"modules":   true,
+	"templates": true,
+	"fields":    true,
+}
+
+// composer builds one recipe out of a document and the files it includes
+type composer struct {
+	// file of every node, for the positions of validation problems
+	files map[*yaml.Node]string
+	// files being included, to report cycles
+	stack []string
+	// every file read
+	read     []string
+	problems []Problem
+	// keep ${...} references as written
+	keepRefs bool
+}
+
+func newComposer() *composer {
+	return &composer{files: map[*yaml.Node]string{}}
+}
+
+// decodeFile composes the recipe file path into cfg
+func (c *composer) decodeFile(path string, cfg *AppConfig) error {
+	root, err := c.file(path)
+	return c.finish(path, root, err, cfg)
+}
+
+// decodeData composes a recipe given as text into cfg, its includes are
+// relative to the working directory
+func (c *composer) decodeData(name string, data []byte, cfg *AppConfig) error {
+	root, err := c.document(name, data, ".")
+	return c.finish(name, root, err, cfg)
+}
+
+// finish applies the environment and the templates to the composed root,
+// then decodes it. Unresolved references are reported first, they are
+// often the cause of the other errors.
+func (c *composer) finish(name string, root *yaml.Node, err error, cfg *AppConfig) error {
+	root, err = c.apply(root, err)
+	if len(c.problems) > 0 {
+		return &ReferenceError{Problems: c.problems}
+	}
+	if err != nil {
+		return err
+	}
+	if root == nil {
+		return nil // empty document
+	}
+
+	src := &recipeSource{name: name, root: root, files: c.files, paths: c.read}
+	if err := root.Decode(cfg); err != nil {
+		// the schema tells the same with the key and the file of the value
+		if problems := src.checkSchema(); len(problems) > 0 {
+			return &ValidationError{Problems: problems}
+		}
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	cfg.sources = append(cfg.sources, src)
+	return nil
+}
+
+// apply lays the environment overlay, then the templates
+func (c *composer) apply(root *yaml.Node, err error) (*yaml.Node, error) {
+	if err != nil || root == nil {
+		return root, err
+	}
+	if root, err = c.overlay(root); err != nil {
+		return nil, err
+	}
+	return c.extend(root)
+}
+
+// Compose reads the recipe file path with its includes, environment and
+// templates, references are kept as written. For printing a recipe, path
+// may be empty to auto-detect.
+func Compose(path string) (*yaml.Node, error) {
+	if path = RecipePath(path); path == "" {
+		return nil, errors.New("no recipe file found")
+	}
+	c := newComposer()
+	c.keepRefs = true
+	return c.apply(c.file(path))
+}
+
+// file reads and composes a recipe file
+func (c *composer) file(path string) (*yaml.Node, error) {
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml", ".json":
+	default:
+		return nil, fmt.Errorf("%s: unsupported recipe format", path)
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(c.stack, abs) {
+		return nil, fmt.Errorf("include cycle: %s", strings.Join(append(c.stack, abs), " -> "))
+	}
+	c.stack = append(c.stack, abs)
+	defer func() { c.stack = c.stack[:len(c.stack)-1] }()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c.read = append(c.read, path)
+	return c.document(path, data, filepath.Dir(path))
+}
+
+// document parses data, expands its references and lays it over the
+// files of its include. JSON is valid YAML, both formats share the parser.
+func (c *composer) document(name string, data []byte, dir string) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	root := doc.Content[0]
+	c.mark(root, name)
+	if !c.keepRefs {
+		expandNode(root, "", func(path string, line int, messages []string) {
+			for _, msg := range messages {
+				c.problems = append(c.problems, Problem{Path: path, File: name, Line: line, Message: msg})
+			}
+		})
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil, c.errorf(root, "a recipe must be a mapping")
+	}
+
+	include := takeKey(root, "include")
+	if include == nil {
+		return root, nil
+	}
+	patterns, err := scalars(include)
+	if err != nil {
+		return nil, c.errorf(include, "include: %v", err)
+	}
+
+	var base *yaml.Node
+	for _, p := range patterns {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, c.errorf(include, "include %s: %v", p, err)
+		}
+		if len(matches) == 0 && !strings.ContainsAny(p, "*?[") {
+			return nil, c.errorf(include, "include %s: no such file", p)
+		}
+		for _, m := range matches {
+			n, err := c.file(m)
+			if err != nil {
+				return nil, err
+			}
+			base = c.merge("", base, n)
+		}
+	}
+	return c.merge("", base, root), nil
+}
+
+// overlay lays the entry of environments named after the environment of
+// the recipe over it
+func (c *composer) overlay(root *yaml.Node) (*yaml.Node, error) {
+	envs := takeKey(root, "environments")
+	if envs == nil {
+		return root, nil
+	}
+	if envs.Kind != yaml.MappingNode {
+		return nil, c.errorf(envs, "environments: must map environment names to recipes")
+	}
+
+	env := os.Getenv(EnvPrefix + "APP__ENVIRONMENT")
+	if env == "" {
+		env = os.Getenv("APP_ENVIRONMENT")
+	}
+	if app := valueOf(root, "app"); env == "" && app != nil {
+		if e := valueOf(app, "environment"); e != nil {
+			env = e.Value
+		}
+	}
+	if o := valueOf(envs, env); env != "" && o != nil {
+		root = c.merge("", root, o)
+	}
+	return root, nil
+}
+
+// extend lays every module with extends over its template. Templates may
+// extend other templates.
+func (c *composer) extend(root *yaml.Node) (*yaml.Node, error) {
+	templates := takeKey(root, "templates")
+	byName := map[string]*yaml.Node{}
+	if templates != nil {
+		if templates.Kind != yaml.SequenceNode {
+			return nil, c.errorf(templates, "templates: must be a list of modules")
+		}
+		for _, t := range templates.Content {
+			byName[entryName(t)] = t
+		}
+	}
+
+	resolved := map[string]*yaml.Node{}
+	var resolve func(name string, seen []string) (*yaml.Node, error)
+	resolve = func(name string, seen []string) (*yaml.Node, error) {
+		if t, ok := resolved[name]; ok {
+			return t, nil
+		}
+		t := byName[name]
+		if t == nil || t.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("unknown template %q", name)
+		}
+		if slices.Contains(seen, name) {
+			return nil, fmt.Errorf("template cycle: %s", strings.Join(append(seen, name), " -> "))
+		}
+		t = c.without(t, "name", "extends")
+		if ext := valueOf(byName[name], "extends"); ext != nil {
+			parent, err := resolve(ext.Value, append(seen, name))
+			if err != nil {
+				return nil, err
+			}
+			t = c.merge("", parent, t)
+		}
+		resolved[name] = t
+		return t, nil
+	}
+
+	modules := valueOf(root, "modules")
+	if modules == nil || modules.Kind != yaml.SequenceNode {
+		return root, nil
+	}
+	for i, m := range modules.Content {
+		ext := valueOf(m, "extends")
+		if ext == nil {
+			continue
+		}
+		t, err := resolve(ext.Value, nil)
+		if err != nil {
+			return nil, c.errorf(ext, "modules[%d].extends: %v", i, err)
+		}
+		modules.Content[i] = c.merge("", t, c.without(m, "extends"))
+	}
+	return root, nil
+}
+
+// merge returns over laid on base, neither is changed. Mappings merge key
+// by key, the named lists by name, anything else is replaced.
+func (c *composer) merge(key string, base, over *yaml.Node) *yaml.Node {
+	if base == nil {
+		return over
+	}
+	if over == nil {
+		return base
+	}
+
+	switch {
+	case base.Kind == yaml.MappingNode && over.Kind == yaml.MappingNode:
+		out := c.container(over, slices.Clone(base.Content))
+		for i := 0; i+1 < len(over.Content); i += 2 {
+			k := over.Content[i].Value
+			if j := valueIndex(out, k); j >= 0 {
+				out.Content[j] = c.merge(k, out.Content[j], over.Content[i+1])
+			} else {
+				out.Content = append(out.Content, over.Content[i], over.Content[i+1])
+			}
+		}
+		return out
+
+	case namedLists[key] && base.Kind == yaml.SequenceNode && over.Kind == yaml.SequenceNode:
+		out := c.container(over, slices.Clone(base.Content))
+		for _, item := range over.Content {
+			name := entryName(item)
+			j := slices.IndexFunc(out.Content, func(n *yaml.Node) bool { return name != "" && entryName(n) == name })
+			if j >= 0 {
+				out.Content[j] = c.merge("", out.Content[j], item)
+			} else {
+				out.Content = append(out.Content, item)
+			}
+		}
+		return out
+	}
+	return over
+}
+
+// container is a new node like n holding content, positioned where n is
+func (c *composer) container(n *yaml.Node, content []*yaml.Node) *yaml.Node {
+	out := &yaml.Node{Kind: n.Kind, Tag: n.Tag, Style: n.Style, Line: n.Line, Column: n.Column, Content: content}
+	c.files[out] = c.files[n]
+	return out
+}
+
+// without returns a copy of mapping m without keys
+func (c *composer) without(m *yaml.Node, keys ...string) *yaml.Node {
+	out := c.container(m, nil)
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if !slices.Contains(keys, m.Content[i].Value) {
+			out.Content = append(out.Content, m.Content[i], m.Content[i+1])
+		}
+	}
+	return out
+}
+
+func (c *composer) mark(n *yaml.Node, name string) {
+	c.files[n] = name
+	for _, child := range n.Content {
+		c.mark(child, name)
+	}
+}
+
+func (c *composer) errorf(n *yaml.Node, format string, args ...any) error {
+	return fmt.Errorf("%s:%d: %s", c.files[n], n.Line, fmt.Sprintf(format, args...))
+}
+
+// -------------------------------------
+
+func valueIndex(m *yaml.Node, key string) int {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+func valueOf(m *yaml.Node, key string) *yaml.Node {
+	if m.Kind != yaml.MappingNode {
+		return nil
+	}
+	if i := valueIndex(m, key); i >= 0 {
+		return m.Content[i]
+	}
+	return nil
+}
+
+// takeKey removes key from the mapping m and returns its value
+func takeKey(m *yaml.Node, key string) *yaml.Node {
+	i := valueIndex(m, key)
+	if i < 0 {
+		return nil
+	}
+	v := m.Content[i]
+	m.Content = slices.Delete(m.Content, i-1, i+1)
+	return v
+}
+
+// entryName is the name of a list entry, a plain string is its own name
+func entryName(n *yaml.Node) string {
+	if n.Kind == yaml.ScalarNode {
+		return n.Value
+	}
+	if v := valueOf(n, "name"); v != nil && v.Kind == yaml.ScalarNode {
+		return v.Value
+	}
+	return ""
+}
+
+// scalars reads a string or a list of strings
+func scalars(n *yaml.Node) ([]string, error) {
+	var l StringList
+	if err := n.Decode(&l); err != nil {
+		return nil, fmt.Errorf("must be a file or a list of files")
+	}
+	return l, nil
+}
@@ -0,0 +1,237 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// writeRecipe writes files into a new directory and returns it
+func writeRecipe(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func composeMap(t *testing.T, path string) map[string]any {
+	t.Helper()
+	root, err := Compose(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]any
+	if err := root.Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+var composeFiles = map[string]string{
+	"base.yaml": `
+server: {host: 0.0.0.0, port: 8000}
+databases:
+  - {name: main, engine: postgres, uri: base}
+  - {name: cache, engine: mysql, uri: cache}
+`,
+	"extra.yaml": `
+server: {port: 8100}
+databases:
+  - {name: main, uri: extra}
+`,
+	"recipe.yaml": `
+include: [base.yaml, extra.yaml]
+server: {port: 9000}
+databases:
+  - {name: logs, engine: mongo, uri: logs}
+environments:
+  prod:
+    server: {port: 443}
+    databases: [{name: main, uri: prod}]
+templates:
+  - {name: crud, operations: [create, read_list, delete], auth: a, fields: [{name: title, type: string}]}
+  - {name: owned, extends: crud, owner_field: owner}
+modules:
+  - {name: notes, extends: owned, auth: b, operations: [create, read_list], fields: [{name: title, type: text}, body]}
+`,
+}
+
+func TestComposeMergeOrder(t *testing.T) {
+	dir := writeRecipe(t, composeFiles)
+	notes := map[string]any{
+		"name":        "notes",
+		"auth":        "b",
+		"owner_field": "owner",
+		// other lists are replaced, fields merge on name
+		"operations": []any{"create", "read_list"},
+		"fields":     []any{map[string]any{"name": "title", "type": "text"}, "body"},
+	}
+
+	tests := []struct {
+		env  string
+		want map[string]any
+	}{
+		{
+			// includes in order, then the recipe itself
+			env: "",
+			want: map[string]any{
+				"server": map[string]any{"host": "0.0.0.0", "port": 9000},
+				"databases": []any{
+					map[string]any{"name": "main", "engine": "postgres", "uri": "extra"},
+					map[string]any{"name": "cache", "engine": "mysql", "uri": "cache"},
+					map[string]any{"name": "logs", "engine": "mongo", "uri": "logs"},
+				},
+				"modules": []any{notes},
+			},
+		},
+		{
+			// the environment over everything
+			env: "prod",
+			want: map[string]any{
+				"server": map[string]any{"host": "0.0.0.0", "port": 443},
+				"databases": []any{
+					map[string]any{"name": "main", "engine": "postgres", "uri": "prod"},
+					map[string]any{"name": "cache", "engine": "mysql", "uri": "cache"},
+					map[string]any{"name": "logs", "engine": "mongo", "uri": "logs"},
+				},
+				"modules": []any{notes},
+			},
+		},
+		{
+			env: "staging",
+			want: map[string]any{
+				"server": map[string]any{"host": "0.0.0.0", "port": 9000},
+				"databases": []any{
+					map[string]any{"name": "main", "engine": "postgres", "uri": "extra"},
+					map[string]any{"name": "cache", "engine": "mysql", "uri": "cache"},
+					map[string]any{"name": "logs", "engine": "mongo", "uri": "logs"},
+				},
+				"modules": []any{notes},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run("env="+tt.env, func(t *testing.T) {
+			t.Setenv(EnvPrefix+"APP__ENVIRONMENT", "")
+			t.Setenv("APP_ENVIRONMENT", tt.env)
+			got := composeMap(t, filepath.Join(dir, "recipe.yaml"))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("composed:\n%v\nwant:\n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComposeEnvironmentSource(t *testing.T) {
+	dir := writeRecipe(t, map[string]string{"recipe.yaml": `
+app: {environment: prod}
+server: {port: 1}
+environments:
+  prod: {server: {port: 2}}
+  dev: {server: {port: 3}}
+`})
+	path := filepath.Join(dir, "recipe.yaml")
+	port := func() any { return composeMap(t, path)["server"].(map[string]any)["port"] }
+
+	t.Setenv(EnvPrefix+"APP__ENVIRONMENT", "")
+	t.Setenv("APP_ENVIRONMENT", "")
+	if got := port(); got != 2 {
+		t.Errorf("app.environment: port = %v, want 2", got)
+	}
+	t.Setenv("APP_ENVIRONMENT", "dev")
+	if got := port(); got != 3 {
+		t.Errorf("APP_ENVIRONMENT: port = %v, want 3", got)
+	}
+	t.Setenv(EnvPrefix+"APP__ENVIRONMENT", "prod")
+	if got := port(); got != 2 {
+		t.Errorf("%sAPP__ENVIRONMENT: port = %v, want 2", EnvPrefix, got)
+	}
+}
+
+func TestComposeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		err   string
+	}{
+		{
+			name:  "include cycle",
+			files: map[string]string{"recipe.yaml": "include: a.yaml\n", "a.yaml": "include: recipe.yaml\n"},
+			err:   "include cycle",
+		},
+		{
+			name:  "missing include",
+			files: map[string]string{"recipe.yaml": "include: nope.yaml\n"},
+			err:   "no such file",
+		},
+		{
+			name:  "unknown template",
+			files: map[string]string{"recipe.yaml": "modules: [{name: a, extends: nope}]\n"},
+			err:   `modules[0].extends: unknown template "nope"`,
+		},
+		{
+			name: "template cycle",
+			files: map[string]string{"recipe.yaml": `
+templates: [{name: x, extends: y}, {name: y, extends: x}]
+modules: [{name: a, extends: x}]
+`},
+			err: "template cycle: x -> y -> x",
+		},
+		{
+			name:  "not a mapping",
+			files: map[string]string{"recipe.yaml": "- a\n"},
+			err:   "a recipe must be a mapping",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeRecipe(t, tt.files)
+			_, err := Compose(filepath.Join(dir, "recipe.yaml"))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestMergeLists(t *testing.T) {
+	node := func(s string) *yaml.Node {
+		var doc yaml.Node
+		if err := yaml.Unmarshal([]byte(s), &doc); err != nil {
+			t.Fatal(err)
+		}
+		return doc.Content[0]
+	}
+	tests := []struct {
+		key, base, over, want string
+	}{
+		{"fields", "[a, {name: b, type: int}]", "[{name: b, nullable: true}, c]", "[a, {name: b, type: int, nullable: true}, c]"},
+		{"operations", "[create, delete]", "[read_list]", "[read_list]"},
+		{"modules", "[{name: a, table: x}]", "[{table: y}]", "[{name: a, table: x}, {table: y}]"},
+		{"server", "{port: 1, host: h}", "{port: 2}", "{port: 2, host: h}"},
+		{"server", "{port: 1}", "8080", "8080"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+" "+tt.over, func(t *testing.T) {
+			c := newComposer()
+			var got, want any
+			if err := c.merge(tt.key, node(tt.base), node(tt.over)).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if err := node(tt.want).Decode(&want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("merged = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// -------------------------------------
+// Environment overrides: GOYUMMY_<KEY>__<KEY>...
+// -------------------------------------
+
+// EnvPrefix starts the variables overriding any key of the recipe. Keys
+// are separated by a double underscore, entries of databases, auths,
+// modules and fields are picked by name, other lists by index:
+// GOYUMMY_DATABASES__PRIMARY__URI sets the uri of the database primary.
+// auth and require of a module take an operation or DEFAULT:
+// GOYUMMY_MODULES__VISIT__AUTH__READ_LIST.
+const EnvPrefix = "GOYUMMY_"
+
+var envKeyRe = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// EnvKey is a recipe key or entry name as written in a variable name,
+// auth-jwt is AUTH_JWT
+func EnvKey(s string) string {
+	return envKeyRe.ReplaceAllString(strings.ToUpper(s), "_")
+}
+
+// OverrideError lists the variables that do not match the recipe
+type OverrideError struct {
+	Problems []string
+}
+
+func (e *OverrideError) Error() string {
+	return fmt.Sprintf("recipe has %d invalid override(s):\n  - %s", len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// applyPrefixedEnv sets the value of every GOYUMMY_ variable, in the order
+// of their names. Strings are taken as is, other values are read as YAML
+// so lists can be written [a, b].
+func applyPrefixedEnv(cfg *AppConfig) error {
+	var names []string
+	for _, kv := range os.Environ() {
+		if name, _, _ := strings.Cut(kv, "="); strings.HasPrefix(name, EnvPrefix) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	var problems []string
+	for _, name := range names {
+		path := strings.Split(strings.TrimPrefix(name, EnvPrefix), "__")
+		if err := setPath(reflect.ValueOf(cfg).Elem(), path, os.Getenv(name)); err != nil {
+			problems = append(problems, name+": "+err.Error())
+			continue
+		}
+		log.Info().Msgf("Override %s from %s", strings.ToLower(strings.Join(path, ".")), name)
+	}
+	if len(problems) > 0 {
+		return &OverrideError{Problems: problems}
+	}
+	return nil
+}
+
+func setPath(v reflect.Value, path []string, value string) error {
+	if len(path) == 0 {
+		if v.Kind() == reflect.String {
+			v.SetString(value)
+			return nil
+		}
+		if err := yaml.Unmarshal([]byte(value), v.Addr().Interface()); err != nil {
+			return fmt.Errorf("%q is not a valid %s", value, v.Kind())
+		}
+		return nil
+	}
+	key := path[0]
+	if key == "" {
+		return fmt.Errorf("empty key")
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setPath(v.Elem(), path, value)
+
+	case reflect.Struct:
+		// keys written as one value or per operation, set in their map form
+		switch p := v.Addr().Interface().(type) {
+		case *ModuleAuth:
+			return setMapForm(path, value, p.toMap(), p.fromMap)
+		case *ModuleRequire:
+			if k := strings.ToLower(key); k != "default" && !slices.Contains(Operations, k) {
+				return setPath(reflect.ValueOf(&p.Default).Elem(), path, value)
+			}
+			return setMapForm(path, value, p.toMap(), p.fromMap)
+		}
+		for i := range v.NumField() {
+			f := v.Type().Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if f.IsExported() && name != "" && name != "-" && EnvKey(name) == key {
+				return setPath(v.Field(i), path[1:], value)
+			}
+		}
+		return fmt.Errorf("unknown key %s", key)
+
+	case reflect.Slice:
+		i := entryIndex(v, key)
+		if i < 0 {
+			return fmt.Errorf("no entry %s", key)
+		}
+		return setPath(v.Index(i), path[1:], value)
+
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		// existing keys keep their spelling, new ones are lower case
+		k := strings.ToLower(key)
+		for _, mk := range v.MapKeys() {
+			if EnvKey(mk.String()) == key {
+				k = mk.String()
+				break
+			}
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		mk := reflect.ValueOf(k).Convert(v.Type().Key())
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if cur := v.MapIndex(mk); cur.IsValid() {
+			elem.Set(cur)
+		}
+		if err := setPath(elem, path[1:], value); err != nil {
+			return err
+		}
+		v.SetMapIndex(mk, elem)
+		return nil
+	}
+	return fmt.Errorf("unknown key %s", key)
+}
+
+// setMapForm sets path in m, the map form of a value, then the value
+// from m
+func setMapForm[T any](path []string, value string, m map[string]T, from func(map[string]T)) error {
+	if err := setPath(reflect.ValueOf(&m).Elem(), path, value); err != nil {
+		return err
+	}
+	from(m)
+	return nil
+}
+
+// entryIndex finds an entry of a list by name, or by index
+func entryIndex(list reflect.Value, key string) int {
+	elem := list.Type().Elem()
+	if f, ok := elem.FieldByName("Name"); elem.Kind() == reflect.Struct && ok && f.Type.Kind() == reflect.String {
+		for i := range list.Len() {
+			if EnvKey(list.Index(i).FieldByIndex(f.Index).String()) == key {
+				return i
+			}
+		}
+		return -1
+	}
+	if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < list.Len() {
+		return i
+	}
+	return -1
+}
+
+// -------------------------------------
+// Redaction
+// -------------------------------------
+
+// Redacted is printed in place of a secret
+const Redacted = "[redacted]"
+
+var (
+	// the password of user:password@ in a URL or a MySQL DSN
+	uriPasswordRe = regexp.MustCompile(`^((?:[A-Za-z][A-Za-z0-9+.-]*://)?[^:@/]*):([^@/]*)@`)
+	// password=... of a key/value DSN, quoted or not, or of a query string
+	kvPasswordRe = regexp.MustCompile(`(?i)((?:^|[\s?&;])password\s*=\s*)('(?:[^'\\]|\\.)*'|[^\s&;]*)`)
+	// a value that is only a reference tells where a secret is, not the
+	// secret, its default is hidden
+	onlyRefRe = regexp.MustCompile(`^\$\{([^}]*?)(:-[^}]*)?\}$`)
+)
+
+// Redact hides the values of the fields tagged secret, those tagged
+// secret:"uri" only lose their password, of user:password@ or password=.
+// For printing a recipe.
+func Redact(cfg *AppConfig) {
+	redact(reflect.ValueOf(cfg).Elem())
+}
+
+func redact(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			redact(v.Elem())
+		}
+	case reflect.Slice:
+		for i := range v.Len() {
+			redact(v.Index(i))
+		}
+	case reflect.Struct:
+		for i := range v.NumField() {
+			f := v.Type().Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			if tag := f.Tag.Get("secret"); tag != "" && f.Type.Kind() == reflect.String {
+				v.Field(i).SetString(redactValue(tag, v.Field(i).String()))
+				continue
+			}
+			redact(v.Field(i))
+		}
+	}
+}
+
+// RedactNode hides the secrets of a recipe node tree, by the keys of the
+// fields tagged secret
+func RedactNode(n *yaml.Node) {
+	if n.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			value := n.Content[i+1]
+			tag, ok := secretKeys[n.Content[i].Value]
+			if !ok || value.Kind != yaml.ScalarNode {
+				continue
+			}
+			if v := redactValue(tag, value.Value); v != value.Value {
+				value.Value, value.Style = v, yaml.DoubleQuotedStyle
+			}
+		}
+	}
+	for _, c := range n.Content {
+		RedactNode(c)
+	}
+}
+
+func redactValue(tag, s string) string {
+	if s == "" {
+		return s
+	}
+	if tag != "uri" {
+		return redactSecret(s)
+	}
+	s = uriPasswordRe.ReplaceAllStringFunc(s, func(m string) string {
+		parts := uriPasswordRe.FindStringSubmatch(m)
+		return parts[1] + ":" + redactSecret(parts[2]) + "@"
+	})
+	return kvPasswordRe.ReplaceAllStringFunc(s, func(m string) string {
+		parts := kvPasswordRe.FindStringSubmatch(m)
+		if parts[2] == "" {
+			return m
+		}
+		return parts[1] + redactSecret(strings.Trim(parts[2], "'"))
+	})
+}
+
+func redactSecret(s string) string {
+	ref := onlyRefRe.FindStringSubmatch(s)
+	switch {
+	case ref == nil:
+		return Redacted
+	case ref[2] != "":
+		return "${" + ref[1] + ":-" + Redacted + "}"
+	}
+	return s
+}
+
+// secretKeys are the recipe keys of the fields tagged secret
+var secretKeys = func() map[string]string {
+	keys := map[string]string{}
+	seen := map[reflect.Type]bool{}
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct || seen[t] {
+			return
+		}
+		seen[t] = true
+		for i := range t.NumField() {
+			f := t.Field(i)
+			if tag := f.Tag.Get("secret"); tag != "" {
+				name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+				keys[name] = tag
+			}
+			walk(f.Type)
+		}
+	}
+	walk(reflect.TypeOf(AppConfig{}))
+	return keys
+}()
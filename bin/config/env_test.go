@@ -0,0 +1,198 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func envTestConfig() *AppConfig {
+	cfg := &AppConfig{
+		Databases: []Database{{Name: "primary", Engine: EnginePostgres, URI: "postgres://localhost/app"}},
+		Modules: []Module{{
+			Name:       "visit",
+			Operations: StringList{OpCreate},
+			Auth:       ModuleAuth{Default: "auth-basic"},
+		}},
+		Auths: []Auth{{Name: "auth-jwt", Type: AuthJWT, JWTKeys: []JWTKey{{KID: "k1"}}}},
+	}
+	cfg.Server.Port = 8000
+	cfg.Databases[0].Session = map[string]string{"app.Tenant": "tenant"}
+	return cfg
+}
+
+func TestSetPath(t *testing.T) {
+	tests := []struct {
+		key   string
+		value string
+		get   func(cfg *AppConfig) any
+		want  any
+	}{
+		{"SERVER__PORT", "9000", func(c *AppConfig) any { return c.Server.Port }, 9000},
+		{"SERVER__HOST", "0.0.0.0", func(c *AppConfig) any { return c.Server.Host }, "0.0.0.0"},
+		// strings are taken as is, not read as YAML
+		{"APP__NAME", "yes", func(c *AppConfig) any { return c.App.Name }, "yes"},
+		{"DATABASES__PRIMARY__URI", "postgres://db/app", func(c *AppConfig) any { return c.Databases[0].URI }, "postgres://db/app"},
+		{"DATABASES__PRIMARY__POOL__MAX", "20", func(c *AppConfig) any { return c.Databases[0].Pool.Max }, 20},
+		// lists without names by index
+		{"AUTHS__AUTH_JWT__JWT_KEYS__0__KID", "k2", func(c *AppConfig) any { return c.Auths[0].JWTKeys[0].KID }, "k2"},
+		{"AUTHS__AUTH_JWT__JWT_SECRET", "s", func(c *AppConfig) any { return c.Auths[0].JWTSecret }, "s"},
+		{"MODULES__VISIT__OPERATIONS", "[create, read_list]", func(c *AppConfig) any { return []string(c.Modules[0].Operations) }, []string{OpCreate, OpReadList}},
+		// map keys keep their spelling, new ones are lower case
+		{"DATABASES__PRIMARY__SESSION__APP_TENANT", "claim.org", func(c *AppConfig) any { return c.Databases[0].Session["app.Tenant"] }, "claim.org"},
+		{"DATABASES__PRIMARY__SESSION__APP_USER", "subject", func(c *AppConfig) any { return c.Databases[0].Session["app_user"] }, "subject"},
+		// auth and require per operation
+		{"MODULES__VISIT__AUTH__READ_LIST", "auth-jwt", func(c *AppConfig) any { return c.Modules[0].Auth.For(OpReadList) }, "auth-jwt"},
+		{"MODULES__VISIT__AUTH__DEFAULT", "auth-jwt", func(c *AppConfig) any { return c.Modules[0].Auth.For(OpCreate) }, "auth-jwt"},
+		{"MODULES__VISIT__AUTH", "auth-jwt", func(c *AppConfig) any { return c.Modules[0].Auth }, ModuleAuth{Default: "auth-jwt"}},
+		{"MODULES__VISIT__REQUIRE__ROLES", "[admin]", func(c *AppConfig) any { return []string(c.Modules[0].Require.For(OpCreate).Roles) }, []string{"admin"}},
+		{"MODULES__VISIT__REQUIRE__CREATE__SCOPE", "notes:write", func(c *AppConfig) any { return []string(c.Modules[0].Require.For(OpCreate).Scope) }, []string{"notes:write"}},
+		// pointers are allocated on the way
+		{"TENANCY__FROM", "header", func(c *AppConfig) any { return c.Tenancy.From }, "header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			cfg := envTestConfig()
+			if err := setPath(reflect.ValueOf(cfg).Elem(), strings.Split(tt.key, "__"), tt.value); err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.get(cfg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("value = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetPathErrors(t *testing.T) {
+	tests := []struct {
+		key, value, err string
+	}{
+		{"SERVER__PORTS", "1", "unknown key PORTS"},
+		{"SERVER__PORT", "many", `"many" is not a valid int`},
+		{"DATABASES__REPLICA__URI", "x", "no entry REPLICA"},
+		{"AUTHS__AUTH_JWT__JWT_KEYS__1__KID", "x", "no entry 1"},
+		{"SERVER____PORT", "1", "empty key"},
+		{"SERVER__PORT__MAX", "1", "unknown key MAX"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			err := setPath(reflect.ValueOf(envTestConfig()).Elem(), strings.Split(tt.key, "__"), tt.value)
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestApplyPrefixedEnv(t *testing.T) {
+	t.Setenv(EnvPrefix+"SERVER__PORT", "9000")
+	t.Setenv(EnvPrefix+"DATABASES__PRIMARY__URI", "postgres://db/app")
+	cfg := envTestConfig()
+	if err := applyPrefixedEnv(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != 9000 || cfg.Databases[0].URI != "postgres://db/app" {
+		t.Errorf("not overridden: port %d, uri %s", cfg.Server.Port, cfg.Databases[0].URI)
+	}
+
+	// every bad variable is reported, the good ones still apply
+	t.Setenv(EnvPrefix+"SERVER__NOPE", "1")
+	t.Setenv(EnvPrefix+"MODULES__OTHER__TABLE", "x")
+	t.Setenv(EnvPrefix+"SERVER__PORT", "9100")
+	cfg = envTestConfig()
+	err := applyPrefixedEnv(cfg)
+	var oe *OverrideError
+	if !errors.As(err, &oe) {
+		t.Fatalf("error = %v, want *OverrideError", err)
+	}
+	want := []string{
+		EnvPrefix + "MODULES__OTHER__TABLE: no entry OTHER",
+		EnvPrefix + "SERVER__NOPE: unknown key NOPE",
+	}
+	if !reflect.DeepEqual(oe.Problems, want) {
+		t.Errorf("problems = %q, want %q", oe.Problems, want)
+	}
+	if cfg.Server.Port != 9100 {
+		t.Errorf("port = %d, want 9100", cfg.Server.Port)
+	}
+}
+
+func TestRedactValue(t *testing.T) {
+	tests := []struct {
+		tag, in, want string
+	}{
+		{"true", "s3cret", Redacted},
+		{"true", "", ""},
+		{"true", "${JWT_SECRET}", "${JWT_SECRET}"},
+		{"true", "${JWT_SECRET:-dev}", "${JWT_SECRET:-" + Redacted + "}"},
+		{"true", "prefix-${JWT_SECRET}", Redacted},
+		{"uri", "postgres://app:pw@db:5432/main?sslmode=disable", "postgres://app:" + Redacted + "@db:5432/main?sslmode=disable"},
+		{"uri", "postgres://app@db/main", "postgres://app@db/main"},
+		{"uri", "postgres://app:${PG_PASS}@db/main", "postgres://app:${PG_PASS}@db/main"},
+		{"uri", "app:pw@tcp(db:3306)/main", "app:" + Redacted + "@tcp(db:3306)/main"},
+		{"uri", "mongodb://app:pw@db/main?authSource=admin", "mongodb://app:" + Redacted + "@db/main?authSource=admin"},
+		{"uri", "host=localhost user=app password=Sup3rS3cret dbname=main", "host=localhost user=app password=" + Redacted + " dbname=main"},
+		{"uri", "host=localhost password = 'with space' dbname=main", "host=localhost password = " + Redacted + " dbname=main"},
+		{"uri", "password=${PG_PASS} host=localhost", "password=${PG_PASS} host=localhost"},
+		{"uri", "Server=db;Password=pw;Database=main", "Server=db;Password=" + Redacted + ";Database=main"},
+		{"uri", "postgres://db/main?user=app&password=pw&sslmode=require", "postgres://db/main?user=app&password=" + Redacted + "&sslmode=require"},
+		{"uri", "host=localhost dbname=main", "host=localhost dbname=main"},
+		{"uri", "host=localhost old_password=x", "host=localhost old_password=x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := redactValue(tt.tag, tt.in); got != tt.want {
+				t.Errorf("redacted = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	cfg := envTestConfig()
+	cfg.Databases[0].URI = "host=db password=pw"
+	cfg.Auths[0].JWTSecret = "s3cret"
+	cfg.Auths = append(cfg.Auths, Auth{Name: "keys", Type: AuthJWT, JWTKeys: []JWTKey{{KID: "k1", Secret: "k1-secret"}}})
+	Redact(cfg)
+	if cfg.Databases[0].URI != "host=db password="+Redacted {
+		t.Errorf("uri = %q", cfg.Databases[0].URI)
+	}
+	if cfg.Auths[0].JWTSecret != Redacted || cfg.Auths[1].JWTKeys[0].Secret != Redacted {
+		t.Errorf("secrets left: %q, %q", cfg.Auths[0].JWTSecret, cfg.Auths[1].JWTKeys[0].Secret)
+	}
+	if cfg.Auths[1].JWTKeys[0].KID != "k1" {
+		t.Errorf("kid redacted: %q", cfg.Auths[1].JWTKeys[0].KID)
+	}
+}
+
+func TestRedactNode(t *testing.T) {
+	var doc yaml.Node
+	src := `
+databases:
+  - {name: main, uri: "host=db user=app password=pw"}
+auths:
+  - {name: a, jwt_secret: s3cret, jwt_issuer: me}
+  - {name: b, jwt_secret: "${JWT_SECRET}"}
+`
+	if err := yaml.Unmarshal([]byte(src), &doc); err != nil {
+		t.Fatal(err)
+	}
+	RedactNode(&doc)
+	var got map[string]any
+	if err := doc.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"databases": []any{map[string]any{"name": "main", "uri": "host=db user=app password=" + Redacted}},
+		"auths": []any{
+			map[string]any{"name": "a", "jwt_secret": Redacted, "jwt_issuer": "me"},
+			map[string]any{"name": "b", "jwt_secret": "${JWT_SECRET}"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("redacted = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// -------------------------------------
+// Interpolation
+// -------------------------------------
+
+// ${VAR}, ${VAR:-default} and ${file:/path}, $${ is a literal ${
+var (
+	refRe     = regexp.MustCompile(`\$\$\{|\$\{([^}]*)\}`)
+	envNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// ReferenceError lists every reference of a recipe that could not be resolved
+type ReferenceError struct {
+	Problems []Problem
+}
+
+func (e *ReferenceError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "recipe has %d unresolved reference(s):", len(e.Problems))
+	for _, p := range e.Problems {
+		b.WriteString("\n  - " + p.String())
+	}
+	return b.String()
+}
+
+type refReporter func(path string, line int, messages []string)
+
+// expandNode expands the scalars under n. Plain scalars get their type
+// resolved again, port: ${PORT} decodes as an int.
+func expandNode(n *yaml.Node, path string, report refReporter) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			expandNode(n.Content[i+1], joinPath(path, n.Content[i].Value), report)
+		}
+	case yaml.SequenceNode:
+		for i, c := range n.Content {
+			expandNode(c, fmt.Sprintf("%s[%d]", path, i), report)
+		}
+	case yaml.ScalarNode:
+		v, problems := expandRefs(n.Value)
+		report(path, n.Line, problems)
+		if v != n.Value {
+			n.Value = v
+			if n.Style == 0 {
+				n.Tag = ""
+			}
+		}
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// expandRefs replaces the references in s, unresolved ones are kept and
+// returned as problems
+func expandRefs(s string) (string, []string) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var problems []string
+	out := refRe.ReplaceAllStringFunc(s, func(m string) string {
+		if m == "$${" {
+			return "${"
+		}
+		v, err := resolveRef(m[2 : len(m)-1])
+		if err != nil {
+			problems = append(problems, err.Error())
+			return m
+		}
+		return v
+	})
+	return out, problems
+}
+
+// resolveRef reads a variable or a file. A default is used when the
+// variable is unset or empty, or the file cannot be read. Files lose their
+// trailing newline, as secrets are usually written with one.
+func resolveRef(ref string) (string, error) {
+	name, def, hasDef := strings.Cut(ref, ":-")
+
+	if path, ok := strings.CutPrefix(name, "file:"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if hasDef {
+				return def, nil
+			}
+			var pathErr *fs.PathError
+			if errors.As(err, &pathErr) {
+				err = pathErr.Err
+			}
+			return "", fmt.Errorf("${%s}: cannot read %s: %v", ref, path, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	if !envNameRe.MatchString(name) {
+		return "", fmt.Errorf("${%s}: invalid reference, use ${VAR}, ${VAR:-default} or ${file:/path}", ref)
+	}
+	v, set := os.LookupEnv(name)
+	if hasDef && v == "" {
+		return def, nil
+	}
+	if !set {
+		return "", fmt.Errorf("${%s}: %s is not set", ref, name)
+	}
+	return v, nil
+}
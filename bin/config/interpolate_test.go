@@ -0,0 +1,88 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestExpandRefs(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secret, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+
+	t.Setenv("GY_HOST", "db.local")
+	t.Setenv("GY_EMPTY", "")
+	t.Setenv("GY_PORT", "5432")
+
+	tests := []struct {
+		in       string
+		want     string
+		problems []string
+	}{
+		{"plain", "plain", nil},
+		{"${GY_HOST}", "db.local", nil},
+		{"postgres://${GY_HOST}:${GY_PORT}/app", "postgres://db.local:5432/app", nil},
+		{"${GY_UNSET:-fallback}", "fallback", nil},
+		{"${GY_EMPTY:-fallback}", "fallback", nil},
+		{"${GY_HOST:-fallback}", "db.local", nil},
+		{"${GY_UNSET:-}", "", nil},
+		{"${GY_EMPTY}", "", nil},
+		{"${file:" + secret + "}", "s3cret", nil},
+		{"${file:" + missing + ":-none}", "none", nil},
+		{"$${GY_HOST}", "${GY_HOST}", nil},
+		{"cost $5", "cost $5", nil},
+		{"${GY_UNSET}", "${GY_UNSET}", []string{"${GY_UNSET}: GY_UNSET is not set"}},
+		{"${file:" + missing + "}", "${file:" + missing + "}", []string{"${file:" + missing + "}: cannot read " + missing + ": no such file or directory"}},
+		{"${1BAD}", "${1BAD}", []string{"${1BAD}: invalid reference, use ${VAR}, ${VAR:-default} or ${file:/path}"}},
+		{"${}", "${}", []string{"${}: invalid reference, use ${VAR}, ${VAR:-default} or ${file:/path}"}},
+		{"${GY_A} ${GY_B}", "${GY_A} ${GY_B}", []string{"${GY_A}: GY_A is not set", "${GY_B}: GY_B is not set"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, problems := expandRefs(tt.in)
+			if got != tt.want {
+				t.Errorf("value = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(problems, tt.problems) {
+				t.Errorf("problems = %q, want %q", problems, tt.problems)
+			}
+		})
+	}
+}
+
+func TestExpandNodeTypes(t *testing.T) {
+	t.Setenv("GY_PORT", "8080")
+	t.Setenv("GY_DEBUG", "true")
+
+	var doc yaml.Node
+	src := "port: ${GY_PORT}\nname: \"${GY_PORT}\"\ndebug: ${GY_DEBUG}\nlist:\n  - ${GY_PORT}\n  - ${GY_UNSET}\n"
+	if err := yaml.Unmarshal([]byte(src), &doc); err != nil {
+		t.Fatal(err)
+	}
+	root := doc.Content[0]
+	var reported []string
+	expandNode(root, "", func(path string, line int, messages []string) {
+		for _, m := range messages {
+			reported = append(reported, path+": "+m)
+		}
+	})
+
+	var got map[string]any
+	if err := root.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"port": 8080, "name": "8080", "debug": true, "list": []any{8080, "${GY_UNSET}"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded = %#v, want %#v", got, want)
+	}
+	if want := []string{"list[1]: ${GY_UNSET}: GY_UNSET is not set"}; !reflect.DeepEqual(reported, want) {
+		t.Errorf("reported = %q, want %q", reported, want)
+	}
+}
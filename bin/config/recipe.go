@@ -2,10 +2,10 @@ package config
 
 import (
 	"encoding/json"
-	"errors"
 	"os"
-	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 
@@ -28,38 +28,260 @@ type AppConfig struct {
 		Output string `yaml:"output" json:"output"`
 	} `yaml:"logging" json:"logging"`
 
-	Databases []struct {
-		Name   string `yaml:"name" json:"name"`
-		Engine string `yaml:"engine" json:"engine"`
-		URI    string `yaml:"uri" json:"uri"`
-		Pool   struct {
-			Max int `yaml:"max" json:"max"`
-			Min int `yaml:"min" json:"min"`
-		} `yaml:"pool" json:"pool"`
-	} `yaml:"databases" json:"databases"`
+	Databases []Database `yaml:"databases" json:"databases"`
 
 	Modules []Module `yaml:"modules" json:"modules"`
 
 	Auths []Auth `yaml:"auths" json:"auths"`
+
+	Tenancy *Tenancy `yaml:"tenancy,omitempty" json:"tenancy,omitempty"`
+
+	// parsed documents, used to point validation problems at a line
+	sources []*recipeSource
 }
 
-type Auth struct {
-	Name string `yaml:"name" json:"name"`
-	Type string `yaml:"type" json:"type"`
+// Database engines
+const (
+	EnginePostgres = "postgres"
+	EngineMySQL    = "mysql"
+	EngineMongo    = "mongo"
+)
+
+var Engines = []string{EnginePostgres, EngineMySQL, EngineMongo}
+
+// Module operations
+const (
+	OpCreate     = "create"
+	OpReadList   = "read_list"
+	OpReadSingle = "read_single"
+	OpUpdate     = "update"
+	OpDelete     = "delete"
+)
 
-	JWTPubKey64   string `yaml:"jwt_pubkey64,omitempty" json:"jwt_pubkey64,omitempty"`
-	JWTPrivKey64  string `yaml:"jwt_privkey64,omitempty" json:"jwt_privkey64,omitempty"`
-	BasicUsername string `yaml:"basic_username,omitempty" json:"basic_username,omitempty"`
-	BasicPassword string `yaml:"basic_password,omitempty" json:"basic_password,omitempty"`
+var Operations = []string{OpCreate, OpReadList, OpReadSingle, OpUpdate, OpDelete}
+
+type Database struct {
+	Name   string `yaml:"name" json:"name"`
+	Engine string `yaml:"engine" json:"engine"`
+	URI    string `yaml:"uri" json:"uri" secret:"uri"`
+	Pool   struct {
+		Max int `yaml:"max" json:"max"`
+		Min int `yaml:"min" json:"min"`
+	} `yaml:"pool" json:"pool"`
+
+	// auto: create missing tables/columns, verify: refuse to start on drift
+	Migrate string `yaml:"migrate,omitempty" json:"migrate,omitempty"`
+
+	// Session settings of Postgres, set with set_config(name, value, true)
+	// in the transaction of every module request so row level security
+	// policies can read them with current_setting. The value is where the
+	// setting comes from, see SessionSources.
+	Session map[string]string `yaml:"session,omitempty" json:"session,omitempty"`
 }
 
+// Sources of session settings, besides claim.<path> for a jwt claim
+const (
+	SessionSubject = "subject"
+	SessionRoles   = "roles"
+	SessionScopes  = "scopes"
+	SessionAuth    = "auth"
+	SessionTenant  = "tenant"
+
+	SessionClaimPrefix = "claim."
+)
+
+var SessionSources = []string{SessionSubject, SessionRoles, SessionScopes, SessionAuth, SessionTenant}
+
+// sessionSettingRe is a custom Postgres setting, always prefix.name
+var sessionSettingRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)+$`)
+
+// Migrate modes
+const (
+	MigrateOff    = "off"
+	MigrateAuto   = "auto"
+	MigrateVerify = "verify"
+)
+
 type Module struct {
-	Name       string   `yaml:"name" json:"name"`
-	Database   string   `yaml:"database" json:"database"`
-	Table      string   `yaml:"table" json:"table"`
-	Auth       string   `yaml:"auth,omitempty" json:"auth,omitempty"`
-	Fields     []string `yaml:"fields" json:"fields"`
-	Operations []string `yaml:"operations" json:"operations"`
+	Name       string        `yaml:"name" json:"name"`
+	Database   string        `yaml:"database" json:"database"`
+	Table      string        `yaml:"table" json:"table"`
+	Auth       ModuleAuth    `yaml:"auth,omitempty" json:"auth,omitempty"`
+	Require    ModuleRequire `yaml:"require,omitempty" json:"require,omitempty"`
+	Fields     []Field       `yaml:"fields" json:"fields"`
+	Operations []string      `yaml:"operations" json:"operations"`
+
+	Pagination Pagination `yaml:"pagination,omitempty" json:"pagination,omitempty"`
+
+	// OwnerField holds the subject of the caller that created a row, every
+	// operation then only sees the rows of its caller. Callers with one of
+	// the OwnerBypass roles see every row and may set the field.
+	OwnerField  string     `yaml:"owner_field,omitempty" json:"owner_field,omitempty"`
+	OwnerBypass StringList `yaml:"owner_bypass,omitempty" json:"owner_bypass,omitempty"`
+
+	// Shared modules are not scoped to the tenant of the request
+	Shared bool `yaml:"shared,omitempty" json:"shared,omitempty"`
+}
+
+// Pagination of read_list, zero values fall back to the defaults below.
+// In the recipe it can also be written as just the mode.
+type Pagination struct {
+	Mode         string `yaml:"mode,omitempty" json:"mode,omitempty"`
+	DefaultLimit int    `yaml:"default_limit,omitempty" json:"default_limit,omitempty"`
+	MaxLimit     int    `yaml:"max_limit,omitempty" json:"max_limit,omitempty"`
+	CursorSecret string `yaml:"cursor_secret,omitempty" json:"cursor_secret,omitempty" secret:"true"`
+}
+
+// Pagination modes
+const (
+	PaginationOffset = "offset"
+	PaginationCursor = "cursor"
+)
+
+type paginationAlias Pagination
+
+func (p *Pagination) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*p = Pagination{Mode: value.Value}
+		return nil
+	}
+	var a paginationAlias
+	if err := value.Decode(&a); err != nil {
+		return err
+	}
+	*p = Pagination(a)
+	return nil
+}
+
+func (p *Pagination) UnmarshalJSON(data []byte) error {
+	var mode string
+	if err := json.Unmarshal(data, &mode); err == nil {
+		*p = Pagination{Mode: mode}
+		return nil
+	}
+	var a paginationAlias
+	if err := json.Unmarshal(data, &a); err != nil {
+		return err
+	}
+	*p = Pagination(a)
+	return nil
+}
+
+const (
+	DefaultPageLimit = 20
+	DefaultMaxLimit  = 100
+)
+
+// Field types supported by modules
+const (
+	FieldString    = "string"
+	FieldInt       = "int"
+	FieldFloat     = "float"
+	FieldBool      = "bool"
+	FieldTimestamp = "timestamp"
+	FieldJSON      = "json"
+	FieldUUID      = "uuid"
+	FieldDecimal   = "decimal"
+)
+
+// Columns every module has besides the configured fields
+var SystemFields = []Field{
+	{Name: "id", Type: FieldUUID},
+	{Name: "created_at", Type: FieldTimestamp},
+	{Name: "updated_at", Type: FieldTimestamp},
+}
+
+var FieldTypes = []string{
+	FieldString, FieldInt, FieldFloat, FieldBool,
+	FieldTimestamp, FieldJSON, FieldUUID, FieldDecimal,
+}
+
+// Field is a module column. In the recipe it can be written as a plain
+// name (a non-nullable string) or as an object with a type.
+type Field struct {
+	Name     string `yaml:"name" json:"name"`
+	Type     string `yaml:"type,omitempty" json:"type,omitempty"`
+	Nullable bool   `yaml:"nullable,omitempty" json:"nullable,omitempty"`
+	Default  any    `yaml:"default,omitempty" json:"default,omitempty"`
+
+	Validate FieldRules `yaml:"validate,omitempty" json:"validate,omitempty"`
+
+	// Hash stores a one-way hash of the value, e.g. a password. Hashed
+	// fields are write-only: never returned, filtered or sorted on.
+	Hash string `yaml:"hash,omitempty" json:"hash,omitempty"`
+}
+
+// Password hashes supported by Field.Hash
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+)
+
+// IsPasswordHash tells if s looks like a bcrypt or argon2id hash
+func IsPasswordHash(s string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$", "$argon2id$"} {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Field formats supported by FieldRules.Format
+const (
+	FormatEmail = "email"
+	FormatURL   = "url"
+	FormatUUID  = "uuid"
+)
+
+// FieldRules are checked on create and update before touching the database
+type FieldRules struct {
+	Required  bool     `yaml:"required,omitempty" json:"required,omitempty"`
+	MinLength *int     `yaml:"min_length,omitempty" json:"min_length,omitempty"`
+	MaxLength *int     `yaml:"max_length,omitempty" json:"max_length,omitempty"`
+	Min       *float64 `yaml:"min,omitempty" json:"min,omitempty"`
+	Max       *float64 `yaml:"max,omitempty" json:"max,omitempty"`
+	Pattern   string   `yaml:"pattern,omitempty" json:"pattern,omitempty"`
+	Enum      []any    `yaml:"enum,omitempty" json:"enum,omitempty"`
+	Format    string   `yaml:"format,omitempty" json:"format,omitempty"`
+}
+
+type fieldAlias Field
+
+func (f *Field) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*f = Field{Name: value.Value, Type: FieldString}
+		return nil
+	}
+	var a fieldAlias
+	if err := value.Decode(&a); err != nil {
+		return err
+	}
+	*f = Field(a)
+	f.normalize()
+	return nil
+}
+
+func (f *Field) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*f = Field{Name: name, Type: FieldString}
+		return nil
+	}
+	var a fieldAlias
+	if err := json.Unmarshal(data, &a); err != nil {
+		return err
+	}
+	*f = Field(a)
+	f.normalize()
+	return nil
+}
+
+func (f *Field) normalize() {
+	f.Type = strings.ToLower(strings.TrimSpace(f.Type))
+	if f.Type == "" {
+		f.Type = FieldString
+	}
 }
 
 // -------------------------------------
@@ -79,28 +301,34 @@ func detectConfigFile() string {
 	return "" // no file found
 }
 
+// Files returns the files the recipe was read from, its includes too
+func (cfg *AppConfig) Files() []string {
+	var files []string
+	for _, src := range cfg.sources {
+		files = append(files, src.paths...)
+	}
+	return files
+}
+
+// RecipePath returns the file LoadAuto reads for path, "" when there is none
+func RecipePath(path string) string {
+	if path != "" {
+		return path
+	}
+	return detectConfigFile()
+}
+
 func loadFromFile(path string) (*AppConfig, error) {
 	log.Info().Msg("Load Config from File")
 	if path == "" {
 		return &AppConfig{}, nil // no file, empty recipe
 	}
 
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-
 	var cfg AppConfig
-	switch filepath.Ext(path) {
-	case ".yaml", ".yml":
-		err = yaml.Unmarshal(data, &cfg)
-	case ".json":
-		err = json.Unmarshal(data, &cfg)
-	default:
-		return nil, errors.New("unsupported recipe format")
+	if err := newComposer().decodeFile(path, &cfg); err != nil {
+		return nil, err
 	}
-
-	return &cfg, err
+	return &cfg, nil
 }
 
 // -------------------------------------
@@ -118,9 +346,9 @@ func loadFromEnvConfig() (*AppConfig, error) {
 	var err error
 
 	if y != "" {
-		err = yaml.Unmarshal([]byte(y), &cfg)
+		err = newComposer().decodeData("CONFIG_YAML", []byte(y), &cfg)
 	} else {
-		err = json.Unmarshal([]byte(j), &cfg)
+		err = newComposer().decodeData("CONFIG_JSON", []byte(j), &cfg)
 	}
 
 	return &cfg, err
@@ -180,6 +408,12 @@ func merge(dst, src *AppConfig) {
 	if len(src.Auths) > 0 {
 		dst.Auths = src.Auths
 	}
+	if src.Tenancy != nil {
+		dst.Tenancy = src.Tenancy
+	}
+
+	// later sources win when looking up positions
+	dst.sources = append(append([]*recipeSource{}, src.sources...), dst.sources...)
 }
 
 // ------------------------
@@ -190,11 +424,10 @@ func LoadAuto(paths ...string) (*AppConfig, error) {
 	cfg := &AppConfig{}
 
 	var path string
-	if len(paths) > 0 && paths[0] != "" {
+	if len(paths) > 0 {
 		path = paths[0] // user provided recipe path
-	} else {
-		path = detectConfigFile() // auto-detect recipe file
 	}
+	path = RecipePath(path) // auto-detect recipe file when empty
 
 	// 1. Load from file if path available
 	fileCfg, err := loadFromFile(path)
@@ -214,6 +447,9 @@ func LoadAuto(paths ...string) (*AppConfig, error) {
 
 	// 3. ENV overrides (highest priority)
 	applyEnvOverrides(cfg)
+	if err := applyPrefixedEnv(cfg); err != nil {
+		return nil, err
+	}
 
 	return cfg, nil
 }
@@ -228,6 +464,16 @@ func GetDBEngineByName(cfg *AppConfig, name string) string {
 	return "" // not found
 }
 
+// Find Database by Name
+func FindDatabase(cfg *AppConfig, name string) *Database {
+	for _, d := range cfg.Databases {
+		if d.Name == name {
+			return &d
+		}
+	}
+	return nil
+}
+
 // Find Auth by Name
 func FindAuth(cfg *AppConfig, name string) *Auth {
 	for _, a := range cfg.Auths {
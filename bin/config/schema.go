@@ -0,0 +1,369 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// -------------------------------------
+// JSON Schema of the recipe
+// -------------------------------------
+
+// SchemaDialect is the JSON Schema version of RecipeSchema
+const SchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is the part of JSON Schema the recipe schema is written with
+type Schema struct {
+	Dialect     string             `json:"$schema,omitempty"`
+	Title       string             `json:"title,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Ref         string             `json:"$ref,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	Pattern     string             `json:"pattern,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	// false or a *Schema
+	AdditionalProperties any                `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+}
+
+// Allowed values of string keys, by the Go type holding the key (the
+// field name for the inline structs of AppConfig) and the recipe key
+var schemaEnums = map[string][]string{
+	"Logging.level":     {"debug", "info", "warn", "error"},
+	"Database.engine":   Engines,
+	"Database.migrate":  {MigrateOff, MigrateAuto, MigrateVerify},
+	"Module.operations": Operations,
+	"Auth.type":         AuthTypes,
+	"Auth.jwt_alg":      JWTAlgs,
+	"Field.type":        FieldTypes,
+	"Field.hash":        {HashBcrypt, HashArgon2id},
+	"FieldRules.format": {FormatEmail, FormatURL, FormatUUID},
+	"Pagination.mode":   {PaginationOffset, PaginationCursor},
+	"Tenancy.from":      {TenantFromHeader, TenantFromSubdomain, TenantFromClaim},
+	"Tenancy.mode":      {TenancyColumn, TenancySchema, TenancyDatabase},
+}
+
+// Keys of schemaEnums Validate reads in any case, their values are also
+// matched by a case-insensitive pattern. Values of the other keys must be
+// written as listed.
+var schemaFoldEnums = map[string]bool{
+	"Logging.level":     true,
+	"Database.migrate":  true,
+	"Module.operations": true,
+	"Field.type":        true,
+}
+
+var (
+	stringListType    = reflect.TypeOf(StringList{})
+	moduleAuthType    = reflect.TypeOf(ModuleAuth{})
+	moduleRequireType = reflect.TypeOf(ModuleRequire{})
+	requirementType   = reflect.TypeOf(Requirement{})
+	fieldType         = reflect.TypeOf(Field{})
+	paginationType    = reflect.TypeOf(Pagination{})
+	timeType          = reflect.TypeOf(time.Time{})
+)
+
+// RecipeSchema returns the JSON Schema of a recipe, generated from
+// AppConfig. Editors use it to complete and check recipes, Validate
+// checks recipes against it first.
+var RecipeSchema = sync.OnceValue(func() *Schema {
+	g := &schemaGen{defs: map[string]*Schema{}}
+	root := g.object(reflect.TypeOf(AppConfig{}), "AppConfig")
+	root.Dialect = SchemaDialect
+	root.Title = "GoYummy recipe"
+	root.Description = "Databases, auths and modules of a GoYummy service"
+
+	// composition keys, gone once the recipe is composed
+	root.Properties["include"] = g.of(stringListType, nil)
+	root.Properties["templates"] = &Schema{Type: "array", Items: &Schema{Ref: "#/$defs/Module"}}
+	root.Properties["environments"] = &Schema{Type: "object", AdditionalProperties: &Schema{Ref: "#"}}
+	g.defs["Module"].Properties["extends"] = &Schema{Type: "string"}
+
+	root.Defs = g.defs
+	return root
+})
+
+type schemaGen struct {
+	defs map[string]*Schema
+}
+
+// enumOf is the schema of the string values of key, nil for any string
+func enumOf(key string) *Schema {
+	values, ok := schemaEnums[key]
+	if !ok {
+		return nil
+	}
+	enum := &Schema{Type: "string", Enum: values}
+	if !schemaFoldEnums[key] {
+		return enum
+	}
+	// JSON Schema patterns have no flags, every letter lists both cases
+	alts := make([]string, len(values))
+	for i, v := range values {
+		var b strings.Builder
+		for _, r := range v {
+			if lower, upper := unicode.ToLower(r), unicode.ToUpper(r); lower != upper {
+				b.WriteString("[" + string(lower) + string(upper) + "]")
+			} else {
+				b.WriteString(regexp.QuoteMeta(string(r)))
+			}
+		}
+		alts[i] = b.String()
+	}
+	return &Schema{AnyOf: []*Schema{enum, {Type: "string", Pattern: "^(?:" + strings.Join(alts, "|") + ")$"}}}
+}
+
+// of is the schema of a value of type t, enum is the schema of strings
+func (g *schemaGen) of(t reflect.Type, enum *Schema) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case stringListType:
+		item := enum
+		if item == nil {
+			item = &Schema{Type: "string"}
+		}
+		return &Schema{AnyOf: []*Schema{item, {Type: "array", Items: item}}}
+	case moduleAuthType:
+		return &Schema{AnyOf: []*Schema{{Type: "string"}, operationMap(&Schema{Type: "string"})}}
+	case moduleRequireType:
+		req := g.of(requirementType, nil)
+		return &Schema{AnyOf: []*Schema{req, operationMap(req)}}
+	case fieldType:
+		return g.ref(t, func() *Schema {
+			return &Schema{AnyOf: []*Schema{{Type: "string"}, g.object(t, t.Name())}}
+		})
+	case paginationType:
+		return g.ref(t, func() *Schema {
+			return &Schema{AnyOf: []*Schema{enumOf("Pagination.mode"), g.object(t, t.Name())}}
+		})
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.of(t.Elem(), enum)
+	case reflect.String:
+		if enum != nil {
+			return enum
+		}
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice:
+		return &Schema{Type: "array", Items: g.of(t.Elem(), enum)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.of(t.Elem(), nil)}
+	case reflect.Struct:
+		return g.ref(t, func() *Schema { return g.object(t, t.Name()) })
+	}
+	return &Schema{} // any value
+}
+
+// ref adds the schema of the named type t to $defs once
+func (g *schemaGen) ref(t reflect.Type, build func() *Schema) *Schema {
+	if _, ok := g.defs[t.Name()]; !ok {
+		g.defs[t.Name()] = nil // taken, for types holding themselves
+		g.defs[t.Name()] = build()
+	}
+	return &Schema{Ref: "#/$defs/" + t.Name()}
+}
+
+// object is the schema of the struct t by its yaml keys, unknown keys are
+// mistakes. Entries of the named lists need their name.
+func (g *schemaGen) object(t reflect.Type, scope string) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: false}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		key, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if !f.IsExported() || key == "" || key == "-" {
+			continue
+		}
+		if f.Type.Kind() == reflect.Struct && f.Type.Name() == "" {
+			s.Properties[key] = g.object(f.Type, f.Name)
+		} else {
+			s.Properties[key] = g.of(f.Type, enumOf(scope+"."+key))
+		}
+		if key == "name" && t.Name() != "" {
+			s.Required = append(s.Required, key)
+		}
+	}
+	return s
+}
+
+// operationMap is a map of operation, or default, to value
+func operationMap(value *Schema) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{"default": value}, AdditionalProperties: false}
+	for _, op := range Operations {
+		s.Properties[op] = value
+	}
+	return s
+}
+
+// -------------------------------------
+// Schema check
+// -------------------------------------
+
+// schemaChecker checks a recipe document against RecipeSchema as an editor
+// would, null is allowed anywhere as it decodes to the zero value.
+type schemaChecker struct {
+	root *Schema
+	src  *recipeSource
+}
+
+// checkSchema checks every document of the recipe against the schema
+func (cfg *AppConfig) checkSchema() []Problem {
+	var problems []Problem
+	for _, src := range cfg.sources {
+		problems = append(problems, src.checkSchema()...)
+	}
+	return problems
+}
+
+func (s *recipeSource) checkSchema() []Problem {
+	if s.root == nil {
+		return nil
+	}
+	c := &schemaChecker{root: RecipeSchema(), src: s}
+	return c.check(c.root, s.root, "")
+}
+
+func (c *schemaChecker) check(s *Schema, n *yaml.Node, path string) []Problem {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	s = c.resolve(s)
+	if n.Kind == yaml.ScalarNode && n.ShortTag() == "!!null" {
+		return nil
+	}
+
+	if len(s.AnyOf) > 0 {
+		// the branch of the kind of n tells what is wrong best
+		var best []Problem
+		var kinds []string
+		for _, sub := range s.AnyOf {
+			problems := c.check(sub, n, path)
+			if len(problems) == 0 {
+				return nil
+			}
+			t := c.resolve(sub).Type
+			kinds = append(kinds, article(t))
+			if kindOf(t) == n.Kind && (best == nil || len(problems) < len(best)) {
+				best = problems
+			}
+		}
+		if best != nil {
+			return best
+		}
+		return []Problem{c.problem(n, path, "must be %s", strings.Join(kinds, " or "))}
+	}
+
+	if s.Type != "" && !typeMatches(s.Type, n) {
+		return []Problem{c.problem(n, path, "must be %s", article(s.Type))}
+	}
+	if len(s.Enum) > 0 && !slices.Contains(s.Enum, n.Value) {
+		return []Problem{c.problem(n, path, "unknown value %q, expected %s", n.Value, strings.Join(s.Enum, "|"))}
+	}
+	if s.Pattern != "" {
+		if ok, err := regexp.MatchString(s.Pattern, n.Value); err != nil || !ok {
+			return []Problem{c.problem(n, path, "%q does not match %s", n.Value, s.Pattern)}
+		}
+	}
+
+	var problems []Problem
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			if k.Value == "<<" {
+				continue // YAML merge key
+			}
+			if p, ok := s.Properties[k.Value]; ok {
+				problems = append(problems, c.check(p, v, joinPath(path, k.Value))...)
+			} else if more, ok := s.AdditionalProperties.(*Schema); ok {
+				problems = append(problems, c.check(more, v, joinPath(path, k.Value))...)
+			} else if s.AdditionalProperties == false {
+				problems = append(problems, c.problem(k, joinPath(path, k.Value), "unknown key"))
+			}
+		}
+		for _, key := range s.Required {
+			if valueOf(n, key) == nil {
+				problems = append(problems, c.problem(n, joinPath(path, key), "is required"))
+			}
+		}
+	case yaml.SequenceNode:
+		if s.Items != nil {
+			for i, item := range n.Content {
+				problems = append(problems, c.check(s.Items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	}
+	return problems
+}
+
+// resolve follows $ref, the schema only refers to the root and its $defs
+func (c *schemaChecker) resolve(s *Schema) *Schema {
+	for s.Ref != "" {
+		if s.Ref == "#" {
+			s = c.root
+			continue
+		}
+		s = c.root.Defs[strings.TrimPrefix(s.Ref, "#/$defs/")]
+	}
+	return s
+}
+
+func (c *schemaChecker) problem(n *yaml.Node, path, format string, args ...any) Problem {
+	return Problem{Path: path, File: c.src.fileOf(n), Line: n.Line, Message: fmt.Sprintf(format, args...)}
+}
+
+// typeMatches checks the kind of n, any scalar is a string as YAML reads
+// port: 80 and name: 80 alike
+func typeMatches(t string, n *yaml.Node) bool {
+	switch t {
+	case "integer":
+		return n.ShortTag() == "!!int"
+	case "number":
+		return n.ShortTag() == "!!int" || n.ShortTag() == "!!float"
+	case "boolean":
+		return n.ShortTag() == "!!bool"
+	}
+	return n.Kind == kindOf(t)
+}
+
+func kindOf(t string) yaml.Kind {
+	switch t {
+	case "object":
+		return yaml.MappingNode
+	case "array":
+		return yaml.SequenceNode
+	}
+	return yaml.ScalarNode
+}
+
+func article(t string) string {
+	switch t {
+	case "":
+		return "a value"
+	case "object", "array", "integer":
+		return "an " + t
+	}
+	return "a " + t
+}
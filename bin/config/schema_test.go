@@ -0,0 +1,173 @@
+package config
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func checkSchemaOf(t *testing.T, src string) []string {
+	t.Helper()
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(src), &doc); err != nil {
+		t.Fatal(err)
+	}
+	s := &recipeSource{name: "recipe.yaml", root: doc.Content[0]}
+	var got []string
+	for _, p := range s.checkSchema() {
+		got = append(got, p.String())
+	}
+	return got
+}
+
+func TestCheckSchema(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "valid",
+			src: `
+server: {port: 8000}
+logging: {level: info, output: stdout}
+databases:
+  - {name: main, engine: postgres, uri: "postgres://db/app", migrate: auto}
+auths:
+  - {name: auth-jwt, type: jwt, jwt_secret: s, jwt_alg: [HS256]}
+modules:
+  - name: notes
+    database: main
+    operations: [create, read_list]
+    auth: {default: auth-jwt, read_list: public}
+    require: {roles: [admin]}
+    pagination: cursor
+    fields: [title, {name: body, type: string, validate: {max_length: 10}}]
+`,
+		},
+		{
+			name: "null anywhere",
+			src:  "server: ~\ndatabases:\n  - {name: main, uri: ~}\n",
+		},
+		{
+			name: "values read in any case",
+			src:  "logging: {level: INFO}\ndatabases: [{name: m, migrate: Auto}]\nmodules: [{name: n, operations: [Create], fields: [{name: a, type: String}]}]\n",
+		},
+		{
+			name: "values written as listed",
+			src:  "databases: [{name: m, engine: Postgres}]\nauths: [{name: a, type: JWT}]\ntenancy: {from: Header}\n",
+			want: []string{
+				`recipe.yaml:1: databases[0].engine: unknown value "Postgres", expected postgres|mysql|mongo`,
+				`recipe.yaml:2: auths[0].type: unknown value "JWT", expected ` + strings.Join(AuthTypes, "|"),
+				`recipe.yaml:3: tenancy.from: unknown value "Header", expected header|subdomain|claim`,
+			},
+		},
+		{
+			name: "unknown keys",
+			src:  "server: {port: 1, ports: 2}\nmodules: [{name: n, tabel: x}]\n",
+			want: []string{
+				"recipe.yaml:1: server.ports: unknown key",
+				"recipe.yaml:2: modules[0].tabel: unknown key",
+			},
+		},
+		{
+			name: "types",
+			src:  "server: {port: eighty}\nmodules: [{name: n, shared: maybe, fields: {a: b}}]\n",
+			want: []string{
+				"recipe.yaml:1: server.port: must be an integer",
+				"recipe.yaml:2: modules[0].shared: must be a boolean",
+				"recipe.yaml:2: modules[0].fields: must be an array",
+			},
+		},
+		{
+			name: "missing name",
+			src:  "databases: [{engine: mysql}]\n",
+			want: []string{"recipe.yaml:1: databases[0].name: is required"},
+		},
+		{
+			name: "per operation maps",
+			src:  "modules: [{name: n, auth: {read_all: a}, require: {default: {roles: admin}, create: {scopes: x}}}]\n",
+			want: []string{
+				"recipe.yaml:1: modules[0].auth.read_all: unknown key",
+				"recipe.yaml:1: modules[0].require.create.scopes: unknown key",
+			},
+		},
+		{
+			name: "string or object",
+			src:  "modules: [{name: n, pagination: pages, fields: [[a]]}]\n",
+			want: []string{
+				`recipe.yaml:1: modules[0].pagination: unknown value "pages", expected offset|cursor`,
+				"recipe.yaml:1: modules[0].fields[0]: must be a string or an object",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := checkSchemaOf(t, tt.src)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("problems:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+// the published schema accepts what the check accepts: an editor checking
+// a recipe against it agrees with Validate
+func TestSchemaEnumPatterns(t *testing.T) {
+	for key, values := range schemaEnums {
+		s := enumOf(key)
+		if !schemaFoldEnums[key] {
+			if s.Pattern != "" || len(s.AnyOf) > 0 || strings.Join(s.Enum, "|") != strings.Join(values, "|") {
+				t.Errorf("%s: %+v, want an enum only", key, s)
+			}
+			continue
+		}
+		if len(s.AnyOf) != 2 || s.AnyOf[1].Pattern == "" {
+			t.Fatalf("%s: %+v, want an enum or a pattern", key, s)
+		}
+		// ECMA-262 and RE2 read these patterns alike
+		re := regexp.MustCompile(s.AnyOf[1].Pattern)
+		for _, v := range values {
+			for _, variant := range []string{v, strings.ToUpper(v), strings.ToUpper(v[:1]) + v[1:]} {
+				if !re.MatchString(variant) {
+					t.Errorf("%s: pattern %s rejects %q", key, re, variant)
+				}
+			}
+			if re.MatchString(v+"x") || re.MatchString(" "+v) {
+				t.Errorf("%s: pattern %s is not anchored", key, re)
+			}
+		}
+		if re.MatchString("other") {
+			t.Errorf("%s: pattern %s accepts other", key, re)
+		}
+	}
+}
+
+func TestRecipeSchemaJSON(t *testing.T) {
+	data, err := json.Marshal(RecipeSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["$schema"] != SchemaDialect {
+		t.Errorf("$schema = %v", doc["$schema"])
+	}
+	defs, _ := doc["$defs"].(map[string]any)
+	for _, name := range []string{"Module", "Database", "Auth", "Field"} {
+		if defs[name] == nil {
+			t.Errorf("$defs has no %s", name)
+		}
+	}
+	// every $ref points at the root or a definition
+	for _, ref := range regexp.MustCompile(`"\$ref":"([^"]*)"`).FindAllStringSubmatch(string(data), -1) {
+		if name, ok := strings.CutPrefix(ref[1], "#/$defs/"); ref[1] != "#" && (!ok || defs[name] == nil) {
+			t.Errorf("dangling $ref %s", ref[1])
+		}
+	}
+}
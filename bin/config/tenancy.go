@@ -0,0 +1,97 @@
+package config
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Where the tenant of a request is read
+const (
+	TenantFromHeader    = "header"
+	TenantFromSubdomain = "subdomain"
+	TenantFromClaim     = "claim"
+)
+
+// How the rows of tenants are kept apart
+const (
+	TenancyColumn   = "column"
+	TenancySchema   = "schema"
+	TenancyDatabase = "database"
+)
+
+// Defaults of the tenancy section
+const (
+	DefaultTenantHeader   = "X-Tenant-ID"
+	DefaultTenantClaim    = "tenant"
+	DefaultTenantColumn   = "tenant_id"
+	DefaultTenantSchema   = "{tenant}"
+	DefaultTenantDatabase = "{database}_{tenant}"
+)
+
+// TenantIDRe is the form of a tenant id, safe in schema and database names
+var TenantIDRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// Tenancy scopes every module not marked shared to the tenant of the
+// request: a column predicate, a Postgres schema, or a database per tenant.
+type Tenancy struct {
+	From   string `yaml:"from" json:"from"`
+	Header string `yaml:"header,omitempty" json:"header,omitempty"`
+	Domain string `yaml:"domain,omitempty" json:"domain,omitempty"`
+	Claim  string `yaml:"claim,omitempty" json:"claim,omitempty"`
+
+	Mode string `yaml:"mode" json:"mode"`
+	// Column of the tenant id, added to the tables of the modules
+	Column string `yaml:"column,omitempty" json:"column,omitempty"`
+	// Schema and Database are patterns, {tenant} is the tenant id and
+	// {database} the database of the module
+	Schema   string `yaml:"schema,omitempty" json:"schema,omitempty"`
+	Database string `yaml:"database,omitempty" json:"database,omitempty"`
+
+	// Tenants lists the known tenants, any well formed id when empty
+	Tenants StringList `yaml:"tenants,omitempty" json:"tenants,omitempty"`
+}
+
+func (t *Tenancy) HeaderName() string {
+	if t.Header == "" {
+		return DefaultTenantHeader
+	}
+	return t.Header
+}
+
+func (t *Tenancy) ClaimPath() string {
+	if t.Claim == "" {
+		return DefaultTenantClaim
+	}
+	return t.Claim
+}
+
+func (t *Tenancy) ColumnName() string {
+	if t.Column == "" {
+		return DefaultTenantColumn
+	}
+	return t.Column
+}
+
+// SchemaFor returns the Postgres schema of tenant
+func (t *Tenancy) SchemaFor(tenant string) string {
+	pattern := t.Schema
+	if pattern == "" {
+		pattern = DefaultTenantSchema
+	}
+	return strings.ReplaceAll(pattern, "{tenant}", tenant)
+}
+
+// DatabaseFor returns the name of the database of tenant standing for
+// database, an entry of the databases section
+func (t *Tenancy) DatabaseFor(database, tenant string) string {
+	pattern := t.Database
+	if pattern == "" {
+		pattern = DefaultTenantDatabase
+	}
+	return strings.NewReplacer("{tenant}", tenant, "{database}", database).Replace(pattern)
+}
+
+// Scopes tells if module m is scoped to tenants
+func (t *Tenancy) Scopes(m Module) bool {
+	return t != nil && !m.Shared
+}
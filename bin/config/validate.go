@@ -0,0 +1,784 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// -------------------------------------
+// Source positions
+// -------------------------------------
+
+type recipeSource struct {
+	name string
+	root *yaml.Node
+	// file of each node, nodes of includes are not from name
+	files map[*yaml.Node]string
+	// the files read, the recipe and its includes
+	paths []string
+}
+
+func (s *recipeSource) fileOf(n *yaml.Node) string {
+	if f, ok := s.files[n]; ok {
+		return f
+	}
+	return s.name
+}
+
+var pathPartRe = regexp.MustCompile(`([^.\[\]]+)|\[(\d+)\]`)
+
+// lookup returns the node at a path like modules[1].fields[0].name
+func (s *recipeSource) lookup(path string) *yaml.Node {
+	node := s.root
+	for _, m := range pathPartRe.FindAllStringSubmatch(path, -1) {
+		if node == nil {
+			return nil
+		}
+		if m[2] != "" {
+			i, _ := strconv.Atoi(m[2])
+			if node.Kind != yaml.SequenceNode || i >= len(node.Content) {
+				return nil
+			}
+			node = node.Content[i]
+			continue
+		}
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == m[1] {
+				next = node.Content[i+1]
+				break
+			}
+		}
+		node = next
+	}
+	return node
+}
+
+// position finds the closest existing node of path, walking up to the
+// parent when the key itself is missing.
+func (cfg *AppConfig) position(path string) (string, int) {
+	for p := path; p != ""; p = parentPath(p) {
+		for _, src := range cfg.sources {
+			if src.root == nil {
+				continue
+			}
+			if n := src.lookup(p); n != nil {
+				return src.fileOf(n), n.Line
+			}
+		}
+	}
+	return "", 0
+}
+
+func parentPath(path string) string {
+	i := strings.LastIndexAny(path, ".[")
+	if i <= 0 {
+		return ""
+	}
+	return path[:i]
+}
+
+// -------------------------------------
+// Validation
+// -------------------------------------
+
+type Problem struct {
+	Path    string
+	File    string
+	Line    int
+	Message string
+}
+
+func (p Problem) String() string {
+	loc := p.File
+	if p.Line > 0 {
+		loc += ":" + strconv.Itoa(p.Line)
+	}
+	if loc != "" {
+		return loc + ": " + p.Path + ": " + p.Message
+	}
+	return p.Path + ": " + p.Message
+}
+
+// ValidationError lists every problem found in a recipe
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "recipe is invalid (%d problem(s)):", len(e.Problems))
+	for _, p := range e.Problems {
+		b.WriteString("\n  - " + p.String())
+	}
+	return b.String()
+}
+
+type recipeChecker struct {
+	cfg      *AppConfig
+	problems []Problem
+}
+
+func (c *recipeChecker) add(path, format string, args ...any) {
+	file, line := c.cfg.position(path)
+	c.problems = append(c.problems, Problem{
+		Path:    path,
+		File:    file,
+		Line:    line,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Validate checks the whole recipe and returns a *ValidationError
+// with every problem, or nil. Unknown keys and values not allowed by
+// RecipeSchema are reported alone, they often cause the other problems.
+func (cfg *AppConfig) Validate() error {
+	if problems := cfg.checkSchema(); len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	c := &recipeChecker{cfg: cfg}
+
+	if cfg.Server.Port < 1 || cfg.Server.Port > 65535 {
+		c.add("server.port", "must be between 1 and 65535, got %d", cfg.Server.Port)
+	}
+
+	switch strings.ToLower(cfg.Logging.Level) {
+	case "", "debug", "info", "warn", "error":
+	default:
+		c.add("logging.level", "unknown level %q, expected debug|info|warn|error", cfg.Logging.Level)
+	}
+	if cfg.Logging.Output == "" {
+		c.add("logging.output", "is required, use stdout or a file path")
+	}
+
+	databases := map[string]string{}
+	for i, d := range cfg.Databases {
+		path := fmt.Sprintf("databases[%d]", i)
+		if d.Name == "" {
+			c.add(path+".name", "is required")
+		} else if _, dup := databases[d.Name]; dup {
+			c.add(path+".name", "duplicate database %q", d.Name)
+		}
+		databases[d.Name] = d.Engine
+
+		if !slices.Contains(Engines, d.Engine) {
+			c.add(path+".engine", "unknown engine %q, expected %s", d.Engine, strings.Join(Engines, "|"))
+		}
+		if d.URI == "" {
+			c.add(path+".uri", "is required")
+		}
+		if d.Pool.Min < 0 || d.Pool.Max < 0 || (d.Pool.Max > 0 && d.Pool.Min > d.Pool.Max) {
+			c.add(path+".pool", "min (%d) and max (%d) must be positive and min <= max", d.Pool.Min, d.Pool.Max)
+		}
+		switch strings.ToLower(d.Migrate) {
+		case "", MigrateOff, MigrateAuto, MigrateVerify:
+		default:
+			c.add(path+".migrate", "unknown mode %q, expected auto|verify|off", d.Migrate)
+		}
+		c.checkSession(path, d, cfg.Tenancy != nil)
+	}
+
+	auths := map[string]string{}
+	for i, a := range cfg.Auths {
+		path := fmt.Sprintf("auths[%d]", i)
+		if a.Name == "" {
+			c.add(path+".name", "is required")
+		} else if _, dup := auths[a.Name]; dup {
+			c.add(path+".name", "duplicate auth %q", a.Name)
+		}
+		auths[a.Name] = a.Type
+		c.checkAuth(path, a)
+	}
+
+	for i, a := range cfg.Auths {
+		if a.Type == AuthJWT && a.JWTIssue != nil {
+			c.checkIssue(fmt.Sprintf("auths[%d]", i), a, databases, auths)
+		}
+		if a.Type == AuthComposite {
+			c.checkComposite(fmt.Sprintf("auths[%d]", i), a, cfg)
+		}
+	}
+
+	routes := map[string]string{}
+	for i, m := range cfg.Modules {
+		path := fmt.Sprintf("modules[%d]", i)
+		c.checkModule(path, m, databases, auths)
+
+		if slug := slugify(m.Name); slug != "" {
+			if other, dup := routes[slug]; dup {
+				c.add(path+".name", "route /api/%s/v1 is already used by module %q", slug, other)
+			} else {
+				routes[slug] = m.Name
+			}
+		}
+	}
+
+	if cfg.Tenancy != nil {
+		c.checkTenancy(cfg, databases)
+	}
+
+	for i, a := range cfg.Auths {
+		if a.Type != AuthJWT || a.JWTIssue == nil {
+			continue
+		}
+		base := a.JWTIssue.BasePath(a.Name)
+		for slug, name := range routes {
+			if base == "/api/"+slug+"/v1" {
+				c.add(fmt.Sprintf("auths[%d].jwt_issue", i), "path %s is already used by module %q", base, name)
+			}
+		}
+	}
+
+	if len(c.problems) > 0 {
+		// report in document order
+		slices.SortStableFunc(c.problems, func(a, b Problem) int {
+			if a.File != b.File {
+				return strings.Compare(a.File, b.File)
+			}
+			return a.Line - b.Line
+		})
+		return &ValidationError{Problems: c.problems}
+	}
+	return nil
+}
+
+func (c *recipeChecker) checkAuth(path string, a Auth) {
+	switch a.Type {
+	case AuthBasic:
+		c.checkBasic(path, a)
+	case AuthJWT:
+		c.checkJWT(path, a)
+	case AuthAPIKey:
+		c.checkAPIKey(path, a)
+	case AuthIP:
+		c.checkIP(path, a)
+	case AuthComposite:
+		if (len(a.Any) == 0) == (len(a.All) == 0) {
+			c.add(path+".type", "composite auth needs exactly one of any or all")
+		}
+	default:
+		c.add(path+".type", "unknown auth type %q, expected %s", a.Type, strings.Join(AuthTypes, "|"))
+	}
+}
+
+func (c *recipeChecker) checkBasic(path string, a Auth) {
+	if a.BasicUsername == "" && len(a.BasicUsers) == 0 && a.BasicHtpasswd == "" {
+		c.add(path+".type", "basic auth needs basic_username, basic_users or basic_htpasswd")
+	}
+	if a.BasicUsername != "" && a.BasicPassword == "" {
+		c.add(path+".basic_password", "is required for basic auth")
+	}
+	names := map[string]bool{a.BasicUsername: a.BasicUsername != ""}
+	for i, u := range a.BasicUsers {
+		userPath := fmt.Sprintf("%s.basic_users[%d]", path, i)
+		if u.Username == "" {
+			c.add(userPath+".username", "is required")
+		} else if names[u.Username] {
+			c.add(userPath+".username", "duplicate user %q", u.Username)
+		}
+		names[u.Username] = true
+		if !IsPasswordHash(u.PasswordHash) {
+			c.add(userPath+".password_hash", "must be a bcrypt or argon2id hash")
+		}
+	}
+	if a.BasicHtpasswd != "" {
+		if _, err := os.Stat(a.BasicHtpasswd); err != nil {
+			c.add(path+".basic_htpasswd", "cannot read file: %v", err)
+		}
+	}
+}
+
+func (c *recipeChecker) checkIP(path string, a Auth) {
+	if len(a.IPAllow) == 0 {
+		c.add(path+".ip_allow", "is required for ip auth")
+	}
+	c.checkPrefixes(path+".ip_allow", a.IPAllow)
+	c.checkPrefixes(path+".ip_proxies", a.IPProxies)
+}
+
+func (c *recipeChecker) checkPrefixes(path string, list StringList) {
+	for i, v := range list {
+		if _, err := ParsePrefix(v); err != nil {
+			c.add(fmt.Sprintf("%s[%d]", path, i), "%v", err)
+		}
+	}
+}
+
+// checkComposite runs after every auth is known: members must exist and
+// no composite may include itself, even through another one.
+func (c *recipeChecker) checkComposite(path string, a Auth, cfg *AppConfig) {
+	key, names := "any", a.Any
+	if len(a.All) > 0 {
+		key, names = "all", a.All
+	}
+	for i, name := range names {
+		member := FindAuth(cfg, name)
+		switch {
+		case member == nil:
+			c.add(fmt.Sprintf("%s.%s[%d]", path, key, i), "unknown auth %q", name)
+		case includesAuth(cfg, name, a.Name, map[string]bool{}):
+			c.add(fmt.Sprintf("%s.%s[%d]", path, key, i), "auth %q includes %q, composites cannot loop", name, a.Name)
+		}
+	}
+}
+
+// includesAuth tells if auth name is target or a composite reaching it
+func includesAuth(cfg *AppConfig, name, target string, seen map[string]bool) bool {
+	if name == target {
+		return true
+	}
+	if seen[name] {
+		return false
+	}
+	seen[name] = true
+	a := FindAuth(cfg, name)
+	if a == nil || a.Type != AuthComposite {
+		return false
+	}
+	for _, member := range append(append([]string{}, a.Any...), a.All...) {
+		if includesAuth(cfg, member, target, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+var sha256HexRe = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+func (c *recipeChecker) checkAPIKey(path string, a Auth) {
+	if len(a.APIKeys) == 0 && a.APIKeyStore == nil {
+		c.add(path+".type", "apikey auth needs apikey_keys or apikey_store")
+	}
+	for i, k := range a.APIKeys {
+		keyPath := fmt.Sprintf("%s.apikey_keys[%d]", path, i)
+		if k.Owner == "" {
+			c.add(keyPath+".owner", "is required")
+		}
+		if !sha256HexRe.MatchString(k.Hash) {
+			c.add(keyPath+".hash", "must be the sha256 hex of the key, see goyummy apikey")
+		}
+	}
+	if st := a.APIKeyStore; st != nil {
+		if st.Table == "" {
+			c.add(path+".apikey_store.table", "is required")
+		}
+		if !slices.ContainsFunc(c.cfg.Databases, func(d Database) bool { return d.Name == st.Database }) {
+			c.add(path+".apikey_store.database", "unknown database %q", st.Database)
+		}
+	}
+}
+
+func (c *recipeChecker) checkJWT(path string, a Auth) {
+	if a.JWTPubKey64 == "" && a.JWTPrivKey64 == "" && a.JWTSecret == "" && len(a.JWTKeys) == 0 && a.JWKSURL == "" {
+		c.add(path+".type", "jwt auth needs one of jwt_pubkey64, jwt_privkey64, jwt_secret, jwt_keys or jwks_url")
+	}
+	if (a.JWTPubKey64 != "" || a.JWTPrivKey64 != "") && a.JWTSecret != "" {
+		c.add(path+".jwt_secret", "use either a key pair or jwt_secret, add more keys with jwt_keys")
+	}
+	if a.JWTPubKey64 != "" {
+		if _, err := base64.StdEncoding.DecodeString(a.JWTPubKey64); err != nil {
+			c.add(path+".jwt_pubkey64", "is not valid base64")
+		}
+	}
+	for i, alg := range a.JWTAlg {
+		if !slices.Contains(JWTAlgs, alg) {
+			c.add(fmt.Sprintf("%s.jwt_alg[%d]", path, i), "unknown algorithm %q, expected %s", alg, strings.Join(JWTAlgs, "|"))
+		}
+	}
+
+	kids := map[string]bool{}
+	for i, k := range a.JWTKeys {
+		keyPath := fmt.Sprintf("%s.jwt_keys[%d]", path, i)
+		if k.KID == "" {
+			c.add(keyPath+".kid", "is required")
+		} else if kids[k.KID] {
+			c.add(keyPath+".kid", "duplicate kid %q", k.KID)
+		}
+		kids[k.KID] = true
+		if (k.PubKey64 == "") == (k.Secret == "") {
+			c.add(keyPath, "needs exactly one of pubkey64 or secret")
+		} else if k.PubKey64 != "" {
+			if _, err := base64.StdEncoding.DecodeString(k.PubKey64); err != nil {
+				c.add(keyPath+".pubkey64", "is not valid base64")
+			}
+		}
+	}
+
+	if a.JWKSURL != "" && !strings.HasPrefix(a.JWKSURL, "https://") && !strings.HasPrefix(a.JWKSURL, "http://") {
+		c.add(path+".jwks_url", "must be an http(s) URL")
+	}
+	c.checkDuration(path+".jwks_refresh", a.JWKSRefresh)
+	c.checkDuration(path+".jwt_leeway", a.JWTLeeway)
+}
+
+// checkIssue runs after every auth is known, jwt_issue points at modules,
+// databases and basic auths.
+func (c *recipeChecker) checkIssue(path string, a Auth, databases, auths map[string]string) {
+	t := a.JWTIssue
+	issuePath := path + ".jwt_issue"
+
+	if a.JWTPrivKey64 == "" && a.JWTSecret == "" {
+		c.add(issuePath, "needs jwt_privkey64 or jwt_secret to sign tokens")
+	} else if a.JWTPrivKey64 != "" {
+		if _, err := base64.StdEncoding.DecodeString(a.JWTPrivKey64); err != nil {
+			c.add(path+".jwt_privkey64", "is not valid base64")
+		}
+	}
+	if t.Path != "" && !strings.HasPrefix(t.Path, "/") {
+		c.add(issuePath+".path", "must start with /")
+	}
+	c.checkDuration(issuePath+".access_ttl", t.AccessTTL)
+	c.checkDuration(issuePath+".refresh_ttl", t.RefreshTTL)
+
+	if t.Users == nil && t.Basic == "" {
+		c.add(issuePath, "needs users or basic to check credentials")
+	}
+	if t.Basic != "" && auths[t.Basic] != AuthBasic {
+		c.add(issuePath+".basic", "%q is not a basic auth", t.Basic)
+	}
+	if u := t.Users; u != nil {
+		i := slices.IndexFunc(c.cfg.Modules, func(m Module) bool { return m.Name == u.Module })
+		if i < 0 {
+			c.add(issuePath+".users.module", "unknown module %q", u.Module)
+		} else {
+			m := c.cfg.Modules[i]
+			findField := func(name string) *Field {
+				for i := range m.Fields {
+					if m.Fields[i].Name == name {
+						return &m.Fields[i]
+					}
+				}
+				return nil
+			}
+			if findField(u.UsernameFieldName()) == nil {
+				c.add(issuePath+".users.username_field", "module %s has no field %q", m.Name, u.UsernameFieldName())
+			}
+			if f := findField(u.PasswordFieldName()); f == nil {
+				c.add(issuePath+".users.password_field", "module %s has no field %q", m.Name, u.PasswordFieldName())
+			} else if f.Hash == "" {
+				c.add(issuePath+".users.password_field", "field %q must set hash: bcrypt or argon2id", f.Name)
+			}
+			for claim, field := range u.Claims {
+				if findField(field) == nil && !slices.ContainsFunc(SystemFields, func(s Field) bool { return s.Name == field }) {
+					c.add(issuePath+".users.claims."+claim, "module %s has no field %q", m.Name, field)
+				}
+			}
+			// users are read within the tenant of the request, a claim
+			// is not there before login
+			if tn := c.cfg.Tenancy; tn.Scopes(m) && tn.From == TenantFromClaim {
+				c.add(issuePath+".users.module", "module %s is scoped to the tenant of a claim, unknown at login: mark it shared or read the tenant from a header or subdomain", m.Name)
+			}
+		}
+	}
+
+	if t.Denylist.Database == "" {
+		c.add(issuePath+".denylist.database", "is required")
+	} else if _, ok := databases[t.Denylist.Database]; !ok {
+		c.add(issuePath+".denylist.database", "unknown database %q", t.Denylist.Database)
+	}
+	if t.Denylist.Table == "" {
+		c.add(issuePath+".denylist.table", "is required")
+	}
+}
+
+func (c *recipeChecker) checkDuration(path, d string) {
+	if d == "" {
+		return
+	}
+	if v, err := time.ParseDuration(d); err != nil || v < 0 {
+		c.add(path, "invalid duration %q, e.g. 30s or 1h", d)
+	}
+}
+
+func (c *recipeChecker) checkModule(path string, m Module, databases, auths map[string]string) {
+	if m.Name == "" {
+		c.add(path+".name", "is required")
+	}
+	if m.Table == "" {
+		c.add(path+".table", "is required")
+	}
+	if m.Database == "" {
+		c.add(path+".database", "is required")
+	} else if _, ok := databases[m.Database]; !ok {
+		c.add(path+".database", "unknown database %q", m.Database)
+	}
+	for key, name := range m.Auth.Names() {
+		if _, ok := auths[name]; !ok {
+			c.add(authPath(path, m.Auth, key), "unknown auth %q", name)
+		}
+	}
+	for op := range m.Auth.Operations {
+		if !slices.Contains(Operations, op) {
+			c.add(path+".auth."+op, "unknown operation %q, expected default|%s", op, strings.Join(Operations, "|"))
+		}
+	}
+
+	seenOps := map[string]bool{}
+	for i, op := range m.Operations {
+		opPath := fmt.Sprintf("%s.operations[%d]", path, i)
+		op = strings.ToLower(op)
+		if !slices.Contains(Operations, op) {
+			c.add(opPath, "unknown operation %q, expected %s", op, strings.Join(Operations, "|"))
+		} else if seenOps[op] {
+			c.add(opPath, "duplicate operation %q", op)
+		}
+		seenOps[op] = true
+	}
+	c.checkRequire(path, m, auths)
+
+	seenFields := map[string]bool{}
+	for i, f := range m.Fields {
+		c.checkField(fmt.Sprintf("%s.fields[%d]", path, i), f, seenFields)
+	}
+	c.checkOwner(path, m)
+
+	switch m.Pagination.Mode {
+	case "", PaginationOffset, PaginationCursor:
+	default:
+		c.add(path+".pagination", "unknown mode %q, expected offset|cursor", m.Pagination.Mode)
+	}
+	if m.Pagination.DefaultLimit < 0 || m.Pagination.MaxLimit < 0 {
+		c.add(path+".pagination", "limits must be positive")
+	}
+}
+
+// checkRequire makes sure roles and scopes are only asked on operations
+// with an auth.
+func (c *recipeChecker) checkRequire(path string, m Module, auths map[string]string) {
+	for op := range m.Require.Operations {
+		if !slices.Contains(Operations, op) {
+			c.add(path+".require."+op, "unknown operation %q, expected default|%s", op, strings.Join(Operations, "|"))
+		}
+	}
+	for _, op := range m.Operations {
+		op = strings.ToLower(op)
+		if m.Require.For(op).IsZero() {
+			continue
+		}
+		reqPath := path + ".require"
+		_, explicit := m.Require.Operations[op]
+		if explicit {
+			reqPath += "." + op
+		}
+		switch name := m.Auth.For(op); {
+		case name == "" && !explicit:
+			// the default requirement only applies to operations with auth
+		case name == "":
+			c.add(reqPath, "operation %s has roles or scopes but no auth", op)
+		}
+	}
+}
+
+func (c *recipeChecker) checkSession(path string, d Database, tenancy bool) {
+	if len(d.Session) == 0 {
+		return
+	}
+	if d.Engine != EnginePostgres {
+		c.add(path+".session", "session settings need postgres, %q is %s", d.Name, d.Engine)
+	}
+	names := make([]string, 0, len(d.Session))
+	for name := range d.Session {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		source := d.Session[name]
+		if !sessionSettingRe.MatchString(name) {
+			c.add(path+".session", "setting %q must be prefixed, like app.user_id", name)
+		}
+		switch {
+		case source == SessionTenant && !tenancy:
+			c.add(path+".session", "setting %s reads the tenant but there is no tenancy section", name)
+		case slices.Contains(SessionSources, source):
+		case strings.HasPrefix(source, SessionClaimPrefix) && len(source) > len(SessionClaimPrefix):
+		default:
+			c.add(path+".session", "setting %s: unknown source %q, expected %s|claim.<path>", name, source, strings.Join(SessionSources, "|"))
+		}
+	}
+}
+
+// checkTenancy makes sure every module scoped to tenants can be: an auth
+// for a tenant claim or header, Postgres for schemas, the databases of the
+// tenants.
+func (c *recipeChecker) checkTenancy(cfg *AppConfig, databases map[string]string) {
+	t := cfg.Tenancy
+	switch t.From {
+	case TenantFromHeader, TenantFromClaim:
+	case TenantFromSubdomain:
+		if t.Domain == "" {
+			c.add("tenancy.domain", "is required to read the tenant from a subdomain")
+		}
+	default:
+		c.add("tenancy.from", "unknown source %q, expected header|subdomain|claim", t.From)
+	}
+	for i, tenant := range t.Tenants {
+		if !TenantIDRe.MatchString(tenant) {
+			c.add(fmt.Sprintf("tenancy.tenants[%d]", i), "tenant %q must be lowercase letters, digits, _ or -", tenant)
+		}
+	}
+
+	switch t.Mode {
+	case TenancyColumn:
+		if slices.ContainsFunc(SystemFields, func(f Field) bool { return f.Name == t.ColumnName() }) {
+			c.add("tenancy.column", "%q is managed automatically", t.ColumnName())
+		}
+	case TenancySchema:
+		if t.Schema != "" && !strings.Contains(t.Schema, "{tenant}") {
+			c.add("tenancy.schema", "must contain {tenant}")
+		}
+	case TenancyDatabase:
+		if t.Database != "" && !strings.Contains(t.Database, "{tenant}") {
+			c.add("tenancy.database", "must contain {tenant}")
+		}
+	default:
+		c.add("tenancy.mode", "unknown mode %q, expected column|schema|database", t.Mode)
+	}
+
+	for i, m := range cfg.Modules {
+		if !t.Scopes(m) {
+			continue
+		}
+		path := fmt.Sprintf("modules[%d]", i)
+		engine := databases[m.Database]
+
+		switch t.Mode {
+		case TenancyColumn:
+			if slices.ContainsFunc(m.Fields, func(f Field) bool { return f.Name == t.ColumnName() }) {
+				c.add(path+".fields", "tenant column %q is managed automatically and cannot be declared", t.ColumnName())
+			}
+		case TenancySchema:
+			if engine != "" && engine != EnginePostgres {
+				c.add(path+".database", "schema tenancy needs postgres, %q is %s (mark the module shared)", m.Database, engine)
+			}
+			// only the schemas of listed tenants are migrated
+			if d := FindDatabase(cfg, m.Database); d != nil && len(t.Tenants) == 0 && !slices.Contains([]string{"", MigrateOff}, strings.ToLower(d.Migrate)) {
+				c.add(path+".database", "migrate %s of %q needs tenancy.tenants to know the tenant schemas, or use migrate: off and create them outside goyummy", d.Migrate, m.Database)
+			}
+		case TenancyDatabase:
+			for _, tenant := range t.Tenants {
+				name := t.DatabaseFor(m.Database, tenant)
+				if other, ok := databases[name]; !ok {
+					c.add(path+".database", "database %q of tenant %s is not declared", name, tenant)
+				} else if engine != "" && other != engine {
+					c.add(path+".database", "database %q of tenant %s is %s, not %s", name, tenant, other, engine)
+				}
+			}
+		}
+
+		if t.From == TenantFromClaim {
+			for _, op := range m.Operations {
+				if op = strings.ToLower(op); m.Auth.For(op) == "" {
+					c.add(path+".auth", "operation %s has no auth, the tenant claim cannot be read", op)
+				}
+			}
+		}
+		// callers of an authenticated operation only reach the tenant of
+		// their claim, the tokens of a jwt auth are the ones carrying it
+		if t.From == TenantFromHeader || t.From == TenantFromSubdomain {
+			for _, op := range m.Operations {
+				a := FindAuth(cfg, m.Auth.For(strings.ToLower(op)))
+				if a != nil && a.Type != AuthJWT && a.Type != AuthComposite {
+					c.add(authPath(path, m.Auth, strings.ToLower(op)), "operation %s uses %s auth %s, its callers have no %s claim to check the tenant %s against", op, a.Type, a.Name, t.ClaimPath(), t.From)
+				}
+			}
+		}
+	}
+}
+
+// checkOwner makes sure rows can only be scoped where a caller is known
+func (c *recipeChecker) checkOwner(path string, m Module) {
+	if m.OwnerField == "" {
+		if len(m.OwnerBypass) > 0 {
+			c.add(path+".owner_bypass", "needs owner_field")
+		}
+		return
+	}
+	ownerPath := path + ".owner_field"
+
+	// schemaless mongo modules take any field name, SQL ones only write
+	// their fields so the owner must be one of them
+	i := slices.IndexFunc(m.Fields, func(f Field) bool { return f.Name == m.OwnerField })
+	schemaless := len(m.Fields) == 0 && GetDBEngineByName(c.cfg, m.Database) == EngineMongo
+	switch {
+	case i < 0 && !schemaless:
+		c.add(ownerPath, "unknown field %q, declare it in fields", m.OwnerField)
+	case i >= 0 && m.Fields[i].Type != FieldString:
+		c.add(ownerPath, "field %q must be a string", m.OwnerField)
+	case i >= 0 && m.Fields[i].Hash != "":
+		c.add(ownerPath, "field %q cannot be hashed", m.OwnerField)
+	}
+
+	for _, op := range m.Operations {
+		op = strings.ToLower(op)
+		if m.Auth.For(op) == "" {
+			c.add(ownerPath, "operation %s has no auth, rows cannot be scoped to a caller", op)
+		}
+	}
+}
+
+func (c *recipeChecker) checkField(path string, f Field, seen map[string]bool) {
+	switch {
+	case f.Name == "":
+		c.add(path+".name", "is required")
+	case slices.ContainsFunc(SystemFields, func(s Field) bool { return s.Name == f.Name }):
+		c.add(path+".name", "%q is managed automatically and cannot be declared", f.Name)
+	case seen[f.Name]:
+		c.add(path+".name", "duplicate field %q", f.Name)
+	}
+	seen[f.Name] = true
+
+	if !slices.Contains(FieldTypes, f.Type) {
+		c.add(path+".type", "unknown type %q, expected %s", f.Type, strings.Join(FieldTypes, "|"))
+	}
+
+	switch f.Hash {
+	case "":
+	case HashBcrypt, HashArgon2id:
+		if f.Type != FieldString {
+			c.add(path+".hash", "only string fields can be hashed")
+		}
+	default:
+		c.add(path+".hash", "unknown hash %q, expected bcrypt|argon2id", f.Hash)
+	}
+
+	r := f.Validate
+	if r.Pattern != "" {
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			c.add(path+".validate.pattern", "invalid regular expression: %v", err)
+		}
+	}
+	switch r.Format {
+	case "", FormatEmail, FormatURL, FormatUUID:
+	default:
+		c.add(path+".validate.format", "unknown format %q, expected email|url|uuid", r.Format)
+	}
+	if r.MinLength != nil && r.MaxLength != nil && *r.MinLength > *r.MaxLength {
+		c.add(path+".validate", "min_length is greater than max_length")
+	}
+	if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+		c.add(path+".validate", "min is greater than max")
+	}
+}
+
+func authPath(path string, a ModuleAuth, key string) string {
+	if len(a.Operations) == 0 {
+		return path + ".auth"
+	}
+	return path + ".auth." + key
+}
+
+// slugify mirrors utils.ToSlug, which cannot be imported from config
+func slugify(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(strings.TrimSpace(s))), "-")
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/cunkz/goyummy/bin/config"
+	"github.com/cunkz/goyummy/bin/helpers/db"
+)
+
+var errMissingKey = &unauthorized{message: "invalid or missing api key"}
+
+// HashAPIKey returns the sha256 hex of a key, the only form stored. Keys
+// are random, a slow hash would add nothing and forbid the table lookup.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAPIKey returns a random key and its hash
+func NewAPIKey() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key := "gy_" + base64.RawURLEncoding.EncodeToString(b)
+	return key, HashAPIKey(key), nil
+}
+
+// apiKeyAuth checks keys of the recipe first, then the key store
+type apiKeyAuth struct {
+	auth  config.Auth
+	keys  map[string]config.APIKey
+	store *storeTable
+}
+
+func newAPIKeyAuth(cfg *config.AppConfig, reg *db.Registry, a config.Auth) *apiKeyAuth {
+	k := &apiKeyAuth{auth: a, keys: map[string]config.APIKey{}}
+	for _, key := range a.APIKeys {
+		k.keys[key.Hash] = key
+	}
+	if st := a.APIKeyStore; st != nil {
+		t := newStoreTable(cfg, reg, st.Database, st.Table)
+		k.store = &t
+	}
+	return k
+}
+
+func (k *apiKeyAuth) authenticate(c *fiber.Ctx) (*Principal, error) {
+	raw := c.Get(k.auth.APIKeyHeaderName())
+	if raw == "" && k.auth.APIKeyQuery != "" {
+		raw = c.Query(k.auth.APIKeyQuery)
+	}
+	if raw == "" {
+		return nil, errMissingKey
+	}
+
+	hash := HashAPIKey(raw)
+	key, ok := k.keys[hash]
+	if !ok && k.store != nil {
+		var err error
+		if key, ok, err = k.find(c.Context(), hash); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case !ok:
+		return nil, errMissingKey
+	case key.Revoked:
+		return nil, &unauthorized{message: errMissingKey.message, cause: errors.New("key of " + key.Owner + " is revoked")}
+	case key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt):
+		return nil, &unauthorized{message: errMissingKey.message, cause: errors.New("key of " + key.Owner + " is expired")}
+	}
+
+	return &Principal{
+		Auth:    k.auth.Name,
+		Method:  k.auth.Type,
+		Subject: key.Owner,
+		Roles:   key.Roles,
+		Scopes:  key.Scopes,
+	}, nil
+}
+
+// find reads a key of the store by its hash
+func (k *apiKeyAuth) find(ctx context.Context, hash string) (config.APIKey, bool, error) {
+	var (
+		key           = config.APIKey{Hash: hash}
+		roles, scopes sql.NullString
+		expires       db.NullTimestamp
+		revoked       sql.NullBool
+	)
+
+	if k.store.engine == config.EngineMongo {
+		col, err := k.store.collection()
+		if err != nil {
+			return key, false, err
+		}
+		var doc struct {
+			Owner     string     `bson:"owner"`
+			Roles     string     `bson:"roles"`
+			Scopes    string     `bson:"scopes"`
+			ExpiresAt *time.Time `bson:"expires_at"`
+			Revoked   bool       `bson:"revoked"`
+		}
+		err = col.FindOne(ctx, bson.M{"key_hash": hash}).Decode(&doc)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return key, false, nil
+		}
+		if err != nil {
+			return key, false, err
+		}
+		key.Owner, key.ExpiresAt, key.Revoked = doc.Owner, doc.ExpiresAt, doc.Revoked
+		key.Roles, key.Scopes = strings.Fields(doc.Roles), strings.Fields(doc.Scopes)
+		return key, true, nil
+	}
+
+	conn, d, err := k.store.sqlDB()
+	if err != nil {
+		return key, false, err
+	}
+	cols := strings.Join(db.QuoteAll(d, []string{"owner", "roles", "scopes", "expires_at", "revoked"}), ",")
+	query := "SELECT " + cols + " FROM " + d.Quote(k.store.table) +
+		" WHERE " + d.Quote("key_hash") + "=" + d.Placeholder(1) + d.LimitOffset(1, 0)
+	err = conn.QueryRowContext(ctx, query, hash).Scan(&key.Owner, &roles, &scopes, &expires, &revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return key, false, nil
+	}
+	if err != nil {
+		return key, false, err
+	}
+	key.Roles, key.Scopes = strings.Fields(roles.String), strings.Fields(scopes.String)
+	if expires.Valid {
+		key.ExpiresAt = &expires.Time
+	}
+	key.Revoked = revoked.Bool
+	return key, true, nil
+}
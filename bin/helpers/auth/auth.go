@@ -1,68 +1,167 @@
 package auth
 
 import (
-	"encoding/base64"
+	"errors"
 	"fmt"
 
-	jwtware "github.com/gofiber/contrib/jwt"
-	"github.com/golang-jwt/jwt/v5"
-
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/basicauth"
 
 	"github.com/rs/zerolog/log"
 
 	"github.com/cunkz/goyummy/bin/config"
+	"github.com/cunkz/goyummy/bin/helpers/db"
+	"github.com/cunkz/goyummy/bin/helpers/utils"
 )
 
-func BuildAuthMap(cfg *config.AppConfig) (map[string]fiber.Handler, error) {
+// BuildAuthMap builds the middleware of every auth, and the issuers of the
+// jwt auths with jwt_issue, which share the verifier of their middleware.
+// Tables read by the auths and JWKS refreshes belong to reg, which may be
+// nil when nothing is served.
+func BuildAuthMap(cfg *config.AppConfig, reg *db.Registry) (map[string]fiber.Handler, map[string]*Issuer, error) {
 	log.Info().Msg("Build Auth")
+	b := newAuthBuilder(cfg, reg)
 	m := make(map[string]fiber.Handler)
+	for _, a := range cfg.Auths {
+		authenticate, err := b.build(a.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		m[a.Name] = middleware(a.Name, authenticate)
+	}
+
+	return m, b.issuers, nil
+}
 
+// CheckAuths builds every auth as serve does, offline: keys and settings
+// are parsed, a JWKS is not fetched and no table is read.
+func CheckAuths(cfg *config.AppConfig) error {
+	b := newAuthBuilder(cfg, nil)
+	b.offline = true
 	for _, a := range cfg.Auths {
-		switch a.Type {
-
-		case "basic":
-			m[a.Name] = basicauth.New(basicauth.Config{
-				Users: map[string]string{
-					a.BasicUsername: a.BasicPassword,
-				},
-				// Optional: Customize the unauthorized response
-				Unauthorized: func(c *fiber.Ctx) error {
-					return c.Status(fiber.StatusUnauthorized).SendString("Custom Unauthorized Message")
-				},
-			})
-
-		case "jwt":
-			// decode base64 PEM
-			pemBytes, err := base64.StdEncoding.DecodeString(a.JWTPubKey64)
-			if err != nil {
-				return nil, fmt.Errorf("invalid base64 public key for %s: %w", a.Name, err)
+		if _, err := b.build(a.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// authBuilder builds each auth once, composites share the authenticators
+// of their members
+type authBuilder struct {
+	cfg      *config.AppConfig
+	reg      *db.Registry
+	built    map[string]authenticator
+	building map[string]bool
+	issuers  map[string]*Issuer
+	// nothing is fetched, see CheckAuths
+	offline bool
+}
+
+func newAuthBuilder(cfg *config.AppConfig, reg *db.Registry) *authBuilder {
+	return &authBuilder{cfg: cfg, reg: reg, built: map[string]authenticator{}, building: map[string]bool{}, issuers: map[string]*Issuer{}}
+}
+
+func (b *authBuilder) build(name string) (authenticator, error) {
+	if f, ok := b.built[name]; ok {
+		return f, nil
+	}
+	a := config.FindAuth(b.cfg, name)
+	if a == nil {
+		return nil, fmt.Errorf("unknown auth %s", name)
+	}
+	if b.building[name] {
+		return nil, fmt.Errorf("auth %s includes itself", name)
+	}
+	b.building[name] = true
+
+	var f authenticator
+	switch a.Type {
+
+	case "basic":
+		f = NewBasicUsers(*a).authenticate
+
+	case "jwt":
+		v, err := newJWTVerifier(b.cfg, b.reg, *a, b.offline)
+		if err != nil {
+			return nil, err
+		}
+		f = v.authenticate
+		if a.JWTIssue != nil {
+			if b.issuers[name], err = newIssuer(*a, v); err != nil {
+				return nil, err
 			}
+		}
+
+	case "apikey":
+		f = newAPIKeyAuth(b.cfg, b.reg, *a).authenticate
 
-			// parse PEM → *rsa.PublicKey
-			pubKey, err := jwt.ParseRSAPublicKeyFromPEM(pemBytes)
+	case "ip":
+		ip, err := newIPAllow(*a)
+		if err != nil {
+			return nil, err
+		}
+		f = ip.authenticate
+
+	case "composite":
+		names, join := a.Any, anyOf
+		if len(a.All) > 0 {
+			names, join = a.All, allOf
+		}
+		members := []member{}
+		for _, n := range names {
+			mf, err := b.build(n)
 			if err != nil {
-				return nil, fmt.Errorf("invalid RSA public key PEM for %s: %w", a.Name, err)
+				return nil, fmt.Errorf("auth %s: %w", a.Name, err)
 			}
-
-			m[a.Name] = jwtware.New(jwtware.Config{
-				SigningKey: jwtware.SigningKey{
-					JWTAlg: jwtware.RS256,
-					Key:    pubKey,
-				},
-				ErrorHandler: func(c *fiber.Ctx, err error) error {
-					fmt.Print(err)
-					return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-						"error": "invalid or missing token",
-					})
-				},
-			})
-
-		default:
-			return nil, fmt.Errorf("unsupported auth type: %s", a.Type)
+			members = append(members, member{name: n, authenticate: mf})
 		}
+		f = join(*a, members)
+
+	default:
+		return nil, fmt.Errorf("unsupported auth type: %s", a.Type)
 	}
 
-	return m, nil
+	b.built[name] = f
+	return f, nil
+}
+
+// authenticator checks the credentials of a request and returns who made
+// it. Errors are *unauthorized, or a failure of the auth itself.
+type authenticator func(c *fiber.Ctx) (*Principal, error)
+
+// unauthorized rejects a request, message is sent to the client and
+// cause is only logged
+type unauthorized struct {
+	message   string
+	challenge string
+	cause     error
+}
+
+func (e *unauthorized) Error() string {
+	if e.cause != nil {
+		return e.message + ": " + e.cause.Error()
+	}
+	return e.message
+}
+
+var errMissingToken = &unauthorized{message: "invalid or missing token"}
+
+// middleware runs an authenticator, 401 when it rejects the request
+func middleware(name string, authenticate authenticator) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		p, err := authenticate(c)
+		var denied *unauthorized
+		if errors.As(err, &denied) {
+			log.Debug().Err(err).Msgf("Rejected request to %s by %s", c.Path(), name)
+			if denied.challenge != "" {
+				c.Set(fiber.HeaderWWWAuthenticate, denied.challenge)
+			}
+			return utils.ResponseError(c, fiber.StatusUnauthorized, denied.message)
+		}
+		if err != nil {
+			return err
+		}
+		SetPrincipal(c, p)
+		return c.Next()
+	}
 }
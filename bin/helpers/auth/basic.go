@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"github.com/cunkz/goyummy/bin/config"
+)
+
+// The htpasswd file is checked for changes at most this often
+const htpasswdCheckEvery = time.Second
+
+// basicUser has a hash, or the plain password of basic_password
+type basicUser struct {
+	hash  string
+	plain string
+	roles []string
+}
+
+func (u basicUser) check(password string) bool {
+	if u.hash != "" {
+		return CheckPassword(u.hash, password)
+	}
+	return subtle.ConstantTimeCompare([]byte(password), []byte(u.plain)) == 1
+}
+
+// BasicUsers are the users of a basic auth: those of the recipe, then
+// those of its htpasswd file.
+type BasicUsers struct {
+	auth     config.Auth
+	users    map[string]basicUser
+	htpasswd *htpasswdFile
+}
+
+func NewBasicUsers(a config.Auth) *BasicUsers {
+	b := &BasicUsers{auth: a, users: map[string]basicUser{}}
+	if a.BasicUsername != "" {
+		u := basicUser{plain: a.BasicPassword}
+		if config.IsPasswordHash(a.BasicPassword) {
+			u = basicUser{hash: a.BasicPassword}
+		}
+		b.users[a.BasicUsername] = u
+	}
+	for _, u := range a.BasicUsers {
+		b.users[u.Username] = basicUser{hash: u.PasswordHash, roles: u.Roles}
+	}
+	if a.BasicHtpasswd != "" {
+		b.htpasswd = &htpasswdFile{path: a.BasicHtpasswd}
+	}
+	return b
+}
+
+// Check returns the principal of username when password matches
+func (b *BasicUsers) Check(username, password string) (*Principal, bool) {
+	u, ok := b.user(username)
+	if !ok {
+		CheckNoUser(password)
+		return nil, false
+	}
+	if !u.check(password) {
+		return nil, false
+	}
+	return b.principal(username, u), true
+}
+
+// Lookup returns the principal of username as it is now, without a
+// password. For tokens refreshed after a login.
+func (b *BasicUsers) Lookup(username string) (*Principal, bool) {
+	u, ok := b.user(username)
+	if !ok {
+		return nil, false
+	}
+	return b.principal(username, u), true
+}
+
+func (b *BasicUsers) user(username string) (basicUser, bool) {
+	u, ok := b.users[username]
+	if !ok && b.htpasswd != nil {
+		u, ok = b.htpasswd.lookup(username)
+	}
+	return u, ok
+}
+
+func (b *BasicUsers) principal(username string, u basicUser) *Principal {
+	return &Principal{
+		Auth:    b.auth.Name,
+		Method:  b.auth.Type,
+		Subject: username,
+		Roles:   u.roles,
+	}
+}
+
+func (b *BasicUsers) authenticate(c *fiber.Ctx) (*Principal, error) {
+	realm := b.auth.BasicRealm
+	if realm == "" {
+		realm = config.DefaultBasicRealm
+	}
+	denied := &unauthorized{message: "invalid username or password", challenge: fmt.Sprintf("Basic realm=%q", realm)}
+
+	username, password, ok := ParseBasic(c.Get(fiber.HeaderAuthorization))
+	if !ok {
+		return nil, denied
+	}
+	p, ok := b.Check(username, password)
+	if !ok {
+		denied.cause = fmt.Errorf("wrong credentials for %q", username)
+		return nil, denied
+	}
+	return p, nil
+}
+
+// ParseBasic reads the credentials of a Basic Authorization header
+func ParseBasic(header string) (string, string, bool) {
+	scheme, value, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(raw), ":")
+}
+
+// -------------------------------------
+// htpasswd
+// -------------------------------------
+
+// htpasswdFile is read again when its size or mtime changes. A file that
+// cannot be read keeps the users of the last good one.
+type htpasswdFile struct {
+	path    string
+	mu      sync.Mutex
+	users   map[string]basicUser
+	modTime time.Time
+	size    int64
+	checked time.Time
+}
+
+func (f *htpasswdFile) lookup(username string) (basicUser, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if time.Since(f.checked) >= htpasswdCheckEvery {
+		f.checked = time.Now()
+		f.reload()
+	}
+	u, ok := f.users[username]
+	return u, ok
+}
+
+func (f *htpasswdFile) reload() {
+	st, err := os.Stat(f.path)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Cannot read htpasswd file %s", f.path)
+		return
+	}
+	if f.users != nil && st.ModTime().Equal(f.modTime) && st.Size() == f.size {
+		return
+	}
+	users, err := readHtpasswd(f.path)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Cannot read htpasswd file %s", f.path)
+		return
+	}
+	f.users, f.modTime, f.size = users, st.ModTime(), st.Size()
+	log.Info().Msgf("Loaded %d users from %s", len(users), f.path)
+}
+
+// readHtpasswd reads user:hash lines. Only bcrypt and argon2id hashes are
+// accepted, users with the older formats are skipped.
+func readHtpasswd(path string) (map[string]basicUser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	users := map[string]basicUser{}
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		username, hash, ok := strings.Cut(line, ":")
+		if !ok || username == "" {
+			log.Warn().Msgf("%s:%d: not a user:hash line", path, n)
+			continue
+		}
+		if !config.IsPasswordHash(hash) {
+			log.Warn().Msgf("%s:%d: user %s has no bcrypt or argon2id hash, skipped", path, n, username)
+			continue
+		}
+		users[username] = basicUser{hash: hash}
+	}
+	return users, scanner.Err()
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/cunkz/goyummy/bin/config"
+)
+
+// member is an auth of a composite
+type member struct {
+	name         string
+	authenticate authenticator
+}
+
+// anyOf accepts the request with the first member that accepts it. When
+// every member rejects it, the client gets all their challenges.
+func anyOf(a config.Auth, members []member) authenticator {
+	return func(c *fiber.Ctx) (*Principal, error) {
+		var rejected []*unauthorized
+		var failure error
+		for _, m := range members {
+			p, err := m.authenticate(c)
+			if err == nil {
+				return composed(a, []*Principal{p}, []string{m.name}), nil
+			}
+			var denied *unauthorized
+			if errors.As(err, &denied) {
+				rejected = append(rejected, denied)
+			} else if failure == nil {
+				failure = err
+			}
+		}
+		if failure != nil {
+			return nil, failure
+		}
+		return nil, joinRejections(rejected)
+	}
+}
+
+// allOf accepts the request when every member accepts it
+func allOf(a config.Auth, members []member) authenticator {
+	return func(c *fiber.Ctx) (*Principal, error) {
+		principals := make([]*Principal, 0, len(members))
+		names := make([]string, 0, len(members))
+		for _, m := range members {
+			p, err := m.authenticate(c)
+			if err != nil {
+				return nil, err
+			}
+			principals, names = append(principals, p), append(names, m.name)
+		}
+		return composed(a, principals, names), nil
+	}
+}
+
+// composed merges the principals of the members that accepted a request.
+// Subject and claims are those of the first member having them, roles and
+// scopes are the union.
+func composed(a config.Auth, principals []*Principal, names []string) *Principal {
+	p := &Principal{Auth: a.Name}
+	methods := []string{}
+	for i, m := range principals {
+		methods = append(methods, m.Method)
+		if p.Subject == "" {
+			p.Subject = m.Subject
+		}
+		if p.Claims == nil {
+			p.Claims = m.Claims
+		}
+		for _, r := range m.Roles {
+			if !slices.Contains(p.Roles, r) {
+				p.Roles = append(p.Roles, r)
+			}
+		}
+		for _, s := range m.Scopes {
+			if !slices.Contains(p.Scopes, s) {
+				p.Scopes = append(p.Scopes, s)
+			}
+		}
+		// nested composites list their own members
+		if len(m.Via) > 0 {
+			p.Via = append(p.Via, m.Via...)
+		} else {
+			p.Via = append(p.Via, names[i])
+		}
+	}
+	p.Method = strings.Join(methods, "+")
+	return p
+}
+
+func joinRejections(rejected []*unauthorized) *unauthorized {
+	messages, challenges, causes := []string{}, []string{}, []error{}
+	for _, r := range rejected {
+		if !slices.Contains(messages, r.message) {
+			messages = append(messages, r.message)
+		}
+		if r.challenge != "" {
+			challenges = append(challenges, r.challenge)
+		}
+		causes = append(causes, r)
+	}
+	return &unauthorized{
+		message:   strings.Join(messages, ", "),
+		challenge: strings.Join(challenges, ", "),
+		cause:     errors.Join(causes...),
+	}
+}
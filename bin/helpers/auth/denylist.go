@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/cunkz/goyummy/bin/config"
+	"github.com/cunkz/goyummy/bin/helpers/db"
+)
+
+var errNotRevocable = errors.New("token has no revocable id")
+
+// storeTable is a table (or collection) an auth reads at request time.
+// The registry is nil when a CLI command builds the auth map without
+// connecting to the databases.
+type storeTable struct {
+	reg      *db.Registry
+	engine   string
+	database string
+	table    string
+}
+
+func newStoreTable(cfg *config.AppConfig, reg *db.Registry, database, table string) storeTable {
+	return storeTable{
+		reg:      reg,
+		engine:   config.GetDBEngineByName(cfg, database),
+		database: database,
+		table:    table,
+	}
+}
+
+func (t storeTable) collection() (*mongo.Collection, error) {
+	mdb := t.reg.MongoDB(t.database)
+	if mdb == nil {
+		return nil, fmt.Errorf("database %s is not connected", t.database)
+	}
+	return mdb.Collection(t.table), nil
+}
+
+func (t storeTable) sqlDB() (*sql.DB, db.Dialect, error) {
+	conn := t.reg.SQL(t.engine, t.database)
+	d := db.GetDialect(t.engine)
+	if conn == nil || d == nil {
+		return nil, nil, fmt.Errorf("database %s is not connected", t.database)
+	}
+	return conn, d, nil
+}
+
+// denylist keeps the jti of revoked tokens until they expire
+type denylist struct {
+	storeTable
+}
+
+func newDenylist(cfg *config.AppConfig, reg *db.Registry, d config.TokenDenylist) *denylist {
+	return &denylist{newStoreTable(cfg, reg, d.Database, d.Table)}
+}
+
+// ids are uuids, anything else was not issued here and cannot be listed
+func revocableID(jti string) bool {
+	return uuid.Validate(jti) == nil
+}
+
+func (l *denylist) revoked(ctx context.Context, jti string) (bool, error) {
+	if !revocableID(jti) {
+		return false, nil
+	}
+
+	if l.engine == config.EngineMongo {
+		col, err := l.collection()
+		if err != nil {
+			return false, err
+		}
+		n, err := col.CountDocuments(ctx, bson.M{"id": jti})
+		return n > 0, err
+	}
+
+	conn, d, err := l.sqlDB()
+	if err != nil {
+		return false, err
+	}
+	var one int
+	err = conn.QueryRowContext(ctx, "SELECT 1 FROM "+d.Quote(l.table)+" WHERE "+d.Quote("id")+"="+d.Placeholder(1), jti).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// revoke adds jti and drops the entries of tokens already expired. It
+// reports false when jti was listed already: one statement inserts it only
+// if absent, so two requests never both revoke the same token.
+func (l *denylist) revoke(ctx context.Context, jti string, expires time.Time) (bool, error) {
+	if !revocableID(jti) {
+		return false, errNotRevocable
+	}
+	now := time.Now()
+
+	if l.engine == config.EngineMongo {
+		col, err := l.collection()
+		if err != nil {
+			return false, err
+		}
+		if _, err := col.DeleteMany(ctx, bson.M{"expires_at": bson.M{"$lt": now}}); err != nil {
+			return false, err
+		}
+		res, err := col.UpdateOne(ctx, bson.M{"id": jti},
+			bson.M{"$setOnInsert": bson.M{"id": jti, "expires_at": expires, "created_at": now, "updated_at": now}},
+			options.Update().SetUpsert(true))
+		if err != nil {
+			return false, err
+		}
+		return res.UpsertedCount == 1, nil
+	}
+
+	conn, d, err := l.sqlDB()
+	if err != nil {
+		return false, err
+	}
+	table := d.Quote(l.table)
+	if _, err := conn.ExecContext(ctx, "DELETE FROM "+table+" WHERE "+d.Quote("expires_at")+"<"+d.Placeholder(1), now); err != nil {
+		return false, err
+	}
+	// id is the primary key, a second insert of jti is skipped
+	cols := db.QuoteAll(d, []string{"id", "expires_at", "created_at", "updated_at"})
+	insert, conflict := "INSERT INTO", " ON CONFLICT ("+cols[0]+") DO NOTHING"
+	if l.engine == config.EngineMySQL {
+		insert, conflict = "INSERT IGNORE INTO", ""
+	}
+	query := fmt.Sprintf("%s %s (%s, %s, %s, %s) VALUES (%s, %s, %s, %s)%s", insert, table,
+		cols[0], cols[1], cols[2], cols[3], d.Placeholder(1), d.Placeholder(2), d.Placeholder(3), d.Placeholder(4), conflict)
+	res, err := conn.ExecContext(ctx, query, jti, expires, now, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
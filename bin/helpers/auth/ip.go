@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/cunkz/goyummy/bin/config"
+)
+
+// ipAllow accepts requests from the addresses of ip_allow
+type ipAllow struct {
+	auth    config.Auth
+	allow   []netip.Prefix
+	proxies []netip.Prefix
+}
+
+func newIPAllow(a config.Auth) (*ipAllow, error) {
+	f := &ipAllow{auth: a}
+	var err error
+	if f.allow, err = parsePrefixes(a.IPAllow); err != nil {
+		return nil, fmt.Errorf("invalid ip_allow for %s: %w", a.Name, err)
+	}
+	if f.proxies, err = parsePrefixes(a.IPProxies); err != nil {
+		return nil, fmt.Errorf("invalid ip_proxies for %s: %w", a.Name, err)
+	}
+	return f, nil
+}
+
+func (f *ipAllow) authenticate(c *fiber.Ctx) (*Principal, error) {
+	ip, ok := f.clientIP(c)
+	if !ok || !contains(f.allow, ip) {
+		return nil, &unauthorized{message: "address not allowed", cause: fmt.Errorf("client %s", c.IP())}
+	}
+	return &Principal{Auth: f.auth.Name, Method: f.auth.Type, Subject: ip.String()}, nil
+}
+
+// clientIP is the peer address. When the peer is a trusted proxy, the
+// client is the last address of X-Forwarded-For not added by one.
+func (f *ipAllow) clientIP(c *fiber.Ctx) (netip.Addr, bool) {
+	ip, err := netip.ParseAddr(c.Context().RemoteIP().String())
+	if err != nil {
+		return ip, false
+	}
+	ip = ip.Unmap()
+	if !contains(f.proxies, ip) {
+		return ip, true
+	}
+	hops := strings.Split(c.Get(fiber.HeaderXForwardedFor), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return hop, false
+		}
+		if ip = hop.Unmap(); !contains(f.proxies, ip) {
+			return ip, true
+		}
+	}
+	return ip, true
+}
+
+func parsePrefixes(list []string) ([]netip.Prefix, error) {
+	out := make([]netip.Prefix, 0, len(list))
+	for _, v := range list {
+		p, err := config.ParsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func contains(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"github.com/cunkz/goyummy/bin/config"
+)
+
+// Value of the token_type claim, access tokens from other issuers have none
+const (
+	tokenTypeClaim = "token_type"
+	tokenAccess    = "access"
+	tokenRefresh   = "refresh"
+)
+
+// Claims set by the issuer, never taken from the recipe or a user
+var reservedClaims = []string{"sub", "iss", "aud", "exp", "nbf", "iat", "jti", tokenTypeClaim}
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// TokenPair is the response of the token and refresh endpoints
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// Issuer signs the tokens of a jwt auth with jwt_issue
+type Issuer struct {
+	auth       config.Auth
+	method     jwt.SigningMethod
+	key        any
+	verifier   *jwtVerifier
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+// newIssuer signs with the private key or secret of a, its tokens are
+// checked by v, the verifier of the auth
+func newIssuer(a config.Auth, v *jwtVerifier) (*Issuer, error) {
+	t := a.JWTIssue
+	is := &Issuer{auth: a, verifier: v, accessTTL: config.DefaultAccessTTL, refreshTTL: config.DefaultRefreshTTL}
+
+	var err error
+	if a.JWTPrivKey64 != "" {
+		if is.key, err = parsePrivateKey64(a.JWTPrivKey64); err != nil {
+			return nil, fmt.Errorf("invalid private key for %s: %w", a.Name, err)
+		}
+	} else {
+		is.key = []byte(a.JWTSecret)
+	}
+	// the first jwt_alg the key signs with, jwt_alg may list algorithms
+	// of other keys only verified here
+	algs := signingAlgs(is.key)
+	alg := algs[0]
+	if len(a.JWTAlg) > 0 {
+		i := slices.IndexFunc(a.JWTAlg, func(alg string) bool { return slices.Contains(algs, alg) })
+		if i < 0 {
+			return nil, fmt.Errorf("no jwt_alg of %s signs with its key, expected one of %s", a.Name, strings.Join(algs, "|"))
+		}
+		alg = a.JWTAlg[i]
+	}
+	if is.method = jwt.GetSigningMethod(alg); is.method == nil {
+		return nil, fmt.Errorf("unsupported jwt_alg %s for %s", alg, a.Name)
+	}
+
+	if t.AccessTTL != "" {
+		if is.accessTTL, err = time.ParseDuration(t.AccessTTL); err != nil {
+			return nil, fmt.Errorf("invalid access_ttl for %s: %w", a.Name, err)
+		}
+	}
+	if t.RefreshTTL != "" {
+		if is.refreshTTL, err = time.ParseDuration(t.RefreshTTL); err != nil {
+			return nil, fmt.Errorf("invalid refresh_ttl for %s: %w", a.Name, err)
+		}
+	}
+	return is, nil
+}
+
+// signingAlgs returns the algorithms key signs with, the default first
+func signingAlgs(key any) []string {
+	if _, ok := key.([]byte); ok {
+		return []string{"HS256", "HS384", "HS512"}
+	}
+	pub := key.(crypto.Signer).Public()
+	if _, ok := pub.(*rsa.PublicKey); ok {
+		return []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}
+	}
+	return keyAlgs(pub)
+}
+
+// Issue signs an access and a refresh token for subject. claims are added
+// on top of the static claims of the recipe.
+func (is *Issuer) Issue(subject string, claims map[string]any) (*TokenPair, error) {
+	custom := map[string]any{}
+	for k, v := range is.auth.JWTIssue.Claims {
+		custom[k] = v
+	}
+	for k, v := range claims {
+		custom[k] = v
+	}
+	for _, k := range reservedClaims {
+		delete(custom, k)
+	}
+
+	access, err := is.sign(subject, tokenAccess, is.accessTTL, custom)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := is.sign(subject, tokenRefresh, is.refreshTTL, custom)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(is.accessTTL.Seconds()),
+	}, nil
+}
+
+func (is *Issuer) sign(subject, tokenType string, ttl time.Duration, custom map[string]any) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub":          subject,
+		"iat":          now.Unix(),
+		"nbf":          now.Unix(),
+		"exp":          now.Add(ttl).Unix(),
+		"jti":          uuid.New().String(),
+		tokenTypeClaim: tokenType,
+	}
+	if is.auth.JWTIssuer != "" {
+		claims["iss"] = is.auth.JWTIssuer
+	}
+	if len(is.auth.JWTAudience) > 0 {
+		claims["aud"] = []string(is.auth.JWTAudience)
+	}
+	for k, v := range custom {
+		claims[k] = v
+	}
+
+	token := jwt.NewWithClaims(is.method, claims)
+	if kid := is.auth.JWTIssue.KID; kid != "" {
+		token.Header["kid"] = kid
+	}
+	return token.SignedString(is.key)
+}
+
+// ClaimsLoader reads the claims of subject as they are now, ErrInvalidToken
+// when the subject is gone or the refresh token, of claims old, may not
+// be traded here
+type ClaimsLoader func(ctx context.Context, subject string, old map[string]any) (map[string]any, error)
+
+// Refresh trades a refresh token for a new pair. The claims are loaded
+// again so a changed user gets its new roles. The old refresh token is
+// revoked, so each one can be used once.
+func (is *Issuer) Refresh(ctx context.Context, raw string, load ClaimsLoader) (*TokenPair, error) {
+	claims, err := is.parse(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+	if claims[tokenTypeClaim] != tokenRefresh {
+		return nil, ErrInvalidToken
+	}
+	sub, _ := claims.GetSubject()
+	current, err := load(ctx, sub, claims)
+	if err != nil {
+		return nil, err
+	}
+	first, err := is.revokeClaims(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+	if !first {
+		return nil, ErrInvalidToken // used meanwhile
+	}
+	return is.Issue(sub, current)
+}
+
+// Revoke puts an access or refresh token on the denylist
+func (is *Issuer) Revoke(ctx context.Context, raw string) error {
+	claims, err := is.parse(ctx, raw)
+	if err != nil {
+		return err
+	}
+	_, err = is.revokeClaims(ctx, claims)
+	return err
+}
+
+// parse verifies a token issued here that is not revoked yet
+func (is *Issuer) parse(ctx context.Context, raw string) (jwt.MapClaims, error) {
+	token, err := is.verifier.verify(raw)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	jti, _ := claims["jti"].(string)
+	if claims[tokenTypeClaim] == nil || !revocableID(jti) {
+		return nil, ErrInvalidToken
+	}
+	revoked, err := is.verifier.denylist.revoked(ctx, jti)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// revokeClaims reports false when the token was revoked already
+func (is *Issuer) revokeClaims(ctx context.Context, claims jwt.MapClaims) (bool, error) {
+	jti, _ := claims["jti"].(string)
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return false, ErrInvalidToken
+	}
+	return is.verifier.denylist.revoke(ctx, jti, exp.Time)
+}
@@ -0,0 +1,264 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/MicahParks/keyfunc/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog/log"
+
+	"github.com/cunkz/goyummy/bin/config"
+	"github.com/cunkz/goyummy/bin/helpers/db"
+)
+
+// Algorithms accepted from a JWKS when jwt_alg is not set. HMAC is left
+// out on purpose, a public key set must never verify HS tokens.
+var jwksAlgs = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// jwtVerifier checks tokens of one jwt auth
+type jwtVerifier struct {
+	auth   config.Auth
+	parser *jwt.Parser
+	// static keys by kid, "" is the key of jwt_pubkey64 or jwt_secret
+	keys map[string]any
+	jwks *keyfunc.JWKS
+	// set when the auth issues its own tokens
+	denylist *denylist
+}
+
+// newJWTVerifier parses the keys of a, offline a JWKS is not fetched
+func newJWTVerifier(cfg *config.AppConfig, reg *db.Registry, a config.Auth, offline bool) (*jwtVerifier, error) {
+	v := &jwtVerifier{auth: a, keys: map[string]any{}}
+	var algs []string
+
+	if a.JWTIssue != nil {
+		v.denylist = newDenylist(cfg, reg, a.JWTIssue.Denylist)
+	}
+	// tokens signed with jwt_privkey64 verify without a separate public key
+	if a.JWTPrivKey64 != "" && a.JWTPubKey64 == "" {
+		key, err := parsePrivateKey64(a.JWTPrivKey64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key for %s: %w", a.Name, err)
+		}
+		pub := key.(crypto.Signer).Public()
+		v.keys[""] = pub
+		if a.JWTIssue != nil && a.JWTIssue.KID != "" {
+			v.keys[a.JWTIssue.KID] = pub
+		}
+		algs = append(algs, keyAlgs(pub)...)
+	}
+
+	if a.JWTPubKey64 != "" {
+		key, err := parsePublicKey64(a.JWTPubKey64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key for %s: %w", a.Name, err)
+		}
+		v.keys[""] = key
+		algs = append(algs, keyAlgs(key)...)
+	}
+	if a.JWTSecret != "" {
+		v.keys[""] = []byte(a.JWTSecret)
+		algs = append(algs, "HS256")
+	}
+	for _, k := range a.JWTKeys {
+		if k.Secret != "" {
+			v.keys[k.KID] = []byte(k.Secret)
+			algs = append(algs, "HS256")
+			continue
+		}
+		key, err := parsePublicKey64(k.PubKey64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %s for %s: %w", k.KID, a.Name, err)
+		}
+		v.keys[k.KID] = key
+		algs = append(algs, keyAlgs(key)...)
+	}
+
+	if a.JWKSURL != "" {
+		refresh := config.DefaultJWKSRefresh
+		if a.JWKSRefresh != "" {
+			d, err := time.ParseDuration(a.JWKSRefresh)
+			if err != nil {
+				return nil, fmt.Errorf("invalid jwks_refresh for %s: %w", a.Name, err)
+			}
+			refresh = d
+		}
+		if !offline {
+			jwks, err := keyfunc.Get(a.JWKSURL, keyfunc.Options{
+				RefreshInterval:   refresh,
+				RefreshRateLimit:  min(refresh, 5*time.Minute),
+				RefreshTimeout:    10 * time.Second,
+				RefreshUnknownKID: true,
+				// an identity provider down at startup must not stop the service
+				TolerateInitialJWKHTTPError: true,
+				RefreshErrorHandler: func(err error) {
+					log.Warn().Err(err).Msgf("Refresh JWKS of %s", a.Name)
+				},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("jwks_url of %s: %w", a.Name, err)
+			}
+			v.jwks = jwks
+			reg.OnClose(jwks.EndBackground)
+		}
+		algs = append(algs, jwksAlgs...)
+	}
+
+	if len(a.JWTAlg) > 0 {
+		algs = a.JWTAlg
+	}
+	slices.Sort(algs)
+
+	opts := []jwt.ParserOption{jwt.WithValidMethods(slices.Compact(algs))}
+	if a.JWTIssuer != "" {
+		opts = append(opts, jwt.WithIssuer(a.JWTIssuer))
+	}
+	if len(a.JWTAudience) > 0 {
+		opts = append(opts, jwt.WithAudience(a.JWTAudience...))
+	}
+	if a.JWTLeeway != "" {
+		d, err := time.ParseDuration(a.JWTLeeway)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwt_leeway for %s: %w", a.Name, err)
+		}
+		opts = append(opts, jwt.WithLeeway(d))
+	}
+	v.parser = jwt.NewParser(opts...)
+
+	return v, nil
+}
+
+// keyFunc picks the key by the kid header: static keys first, then the
+// JWKS, which is fetched again when it does not know the kid yet.
+func (v *jwtVerifier) keyFunc(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	if v.jwks != nil {
+		return v.jwks.Keyfunc(t)
+	}
+	if key, ok := v.keys[""]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown kid %q", kid)
+}
+
+// verify parses a raw token and checks signature, expiry, issuer and audience
+func (v *jwtVerifier) verify(raw string) (*jwt.Token, error) {
+	return v.parser.Parse(raw, v.keyFunc)
+}
+
+// authenticate verifies the bearer token of the request
+func (v *jwtVerifier) authenticate(c *fiber.Ctx) (*Principal, error) {
+	raw, ok := bearerToken(c)
+	if !ok {
+		return nil, errMissingToken
+	}
+	token, err := v.verify(raw)
+	if err == nil {
+		err = v.checkAccess(c.Context(), token)
+	}
+	if err != nil {
+		return nil, &unauthorized{message: errMissingToken.message, cause: err}
+	}
+
+	c.Locals("user", token)
+	claims, _ := token.Claims.(jwt.MapClaims)
+	return principalFromClaims(v.auth, claims), nil
+}
+
+// checkAccess refuses refresh tokens and revoked tokens
+func (v *jwtVerifier) checkAccess(ctx context.Context, token *jwt.Token) error {
+	claims, _ := token.Claims.(jwt.MapClaims)
+	if claims[tokenTypeClaim] == tokenRefresh {
+		return errors.New("refresh token used as access token")
+	}
+	if v.denylist == nil {
+		return nil
+	}
+	jti, _ := claims["jti"].(string)
+	revoked, err := v.denylist.revoked(ctx, jti)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return errors.New("token is revoked")
+	}
+	return nil
+}
+
+func bearerToken(c *fiber.Ctx) (string, bool) {
+	scheme, token, ok := strings.Cut(c.Get(fiber.HeaderAuthorization), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// parsePublicKey64 reads a base64 PEM holding an RSA, ECDSA or Ed25519 key
+func parsePublicKey64(s string) (any, error) {
+	pemBytes, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %w", err)
+	}
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(pemBytes); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseECPublicKeyFromPEM(pemBytes); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseEdPublicKeyFromPEM(pemBytes); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("not a PEM encoded RSA, ECDSA or Ed25519 public key")
+}
+
+// parsePrivateKey64 reads a base64 PEM holding an RSA, ECDSA or Ed25519 key
+func parsePrivateKey64(s string) (any, error) {
+	pemBytes, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %w", err)
+	}
+	if key, err := jwt.ParseRSAPrivateKeyFromPEM(pemBytes); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseECPrivateKeyFromPEM(pemBytes); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseEdPrivateKeyFromPEM(pemBytes); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("not a PEM encoded RSA, ECDSA or Ed25519 private key")
+}
+
+// keyAlgs returns the default algorithms of a public key
+func keyAlgs(key any) []string {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return []string{"RS256"}
+	case *ecdsa.PublicKey:
+		switch k.Curve.Params().BitSize {
+		case 384:
+			return []string{"ES384"}
+		case 521:
+			return []string{"ES512"}
+		}
+		return []string{"ES256"}
+	case ed25519.PublicKey:
+		return []string{"EdDSA"}
+	}
+	return nil
+}
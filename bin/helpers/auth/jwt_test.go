@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/cunkz/goyummy/bin/config"
+)
+
+// jwksServer stands in for an identity provider, its keys can be rotated
+type jwksServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	keys  map[string]*rsa.PrivateKey
+	calls int
+}
+
+func newJWKSServer(t *testing.T, kids ...string) *jwksServer {
+	t.Helper()
+	s := &jwksServer{keys: map[string]*rsa.PrivateKey{}}
+	s.rotate(t, kids...)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.calls++
+		set := map[string][]map[string]string{"keys": {}}
+		for kid, key := range s.keys {
+			set["keys"] = append(set["keys"], map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		_ = json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// rotate replaces the published keys by new keys of kids
+func (s *jwksServer) rotate(t *testing.T, kids ...string) {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = map[string]*rsa.PrivateKey{}
+	for _, kid := range kids {
+		s.keys[kid] = newRSAKey(t)
+	}
+}
+
+func (s *jwksServer) key(kid string) *rsa.PrivateKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys[kid]
+}
+
+func (s *jwksServer) fetches() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key any) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	raw, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func newTestVerifier(t *testing.T, a config.Auth) *jwtVerifier {
+	t.Helper()
+	v, err := newJWTVerifier(&config.AppConfig{}, nil, a, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.jwks != nil {
+		t.Cleanup(v.jwks.EndBackground)
+	}
+	return v
+}
+
+func TestJWKSKidSelection(t *testing.T) {
+	srv := newJWKSServer(t, "k1", "k2")
+	v := newTestVerifier(t, config.Auth{Name: "idp", Type: config.AuthJWT, JWKSURL: srv.URL, JWKSRefresh: "1h"})
+
+	for _, kid := range []string{"k1", "k2"} {
+		raw := signToken(t, jwt.SigningMethodRS256, kid, srv.key(kid))
+		if _, err := v.verify(raw); err != nil {
+			t.Errorf("token of %s: %v", kid, err)
+		}
+	}
+
+	// signed by k1 but naming k2: the key of the kid is used, not any key
+	raw := signToken(t, jwt.SigningMethodRS256, "k2", srv.key("k1"))
+	if _, err := v.verify(raw); err == nil {
+		t.Error("token signed by k1 with kid k2 was accepted")
+	}
+}
+
+func TestStaticKidSelection(t *testing.T) {
+	k1, k2 := newRSAKey(t), newRSAKey(t)
+	pub64 := func(key *rsa.PrivateKey) string {
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	}
+	v := newTestVerifier(t, config.Auth{Name: "keys", Type: config.AuthJWT, JWTKeys: []config.JWTKey{
+		{KID: "k1", PubKey64: pub64(k1)},
+		{KID: "k2", PubKey64: pub64(k2)},
+		{KID: "hs", Secret: "shared-secret"},
+	}})
+
+	tests := []struct {
+		name   string
+		method jwt.SigningMethod
+		kid    string
+		key    any
+		valid  bool
+	}{
+		{"k1", jwt.SigningMethodRS256, "k1", k1, true},
+		{"k2", jwt.SigningMethodRS256, "k2", k2, true},
+		{"secret", jwt.SigningMethodHS256, "hs", []byte("shared-secret"), true},
+		{"other key of kid", jwt.SigningMethodRS256, "k1", k2, false},
+		{"unknown kid", jwt.SigningMethodRS256, "k3", k1, false},
+		{"no kid", jwt.SigningMethodRS256, "", k1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.verify(signToken(t, tt.method, tt.kid, tt.key))
+			if (err == nil) != tt.valid {
+				t.Errorf("valid = %v, want %v (err: %v)", err == nil, tt.valid, err)
+			}
+		})
+	}
+}
+
+func TestJWKSUnknownKid(t *testing.T) {
+	srv := newJWKSServer(t, "k1")
+	v := newTestVerifier(t, config.Auth{Name: "idp", Type: config.AuthJWT, JWKSURL: srv.URL, JWKSRefresh: "1h"})
+
+	raw := signToken(t, jwt.SigningMethodRS256, "nope", newRSAKey(t))
+	if _, err := v.verify(raw); err == nil {
+		t.Fatal("token of an unknown kid was accepted")
+	}
+	// the unknown kid is looked up once more before giving up
+	if got := srv.fetches(); got != 2 {
+		t.Errorf("JWKS fetched %d time(s), want 2", got)
+	}
+}
+
+func TestJWKSRefreshAfterRotation(t *testing.T) {
+	srv := newJWKSServer(t, "k1")
+	v := newTestVerifier(t, config.Auth{Name: "idp", Type: config.AuthJWT, JWKSURL: srv.URL, JWKSRefresh: "1h"})
+
+	if _, err := v.verify(signToken(t, jwt.SigningMethodRS256, "k1", srv.key("k1"))); err != nil {
+		t.Fatalf("token of k1 before rotation: %v", err)
+	}
+	srv.rotate(t, "k2")
+	if _, err := v.verify(signToken(t, jwt.SigningMethodRS256, "k2", srv.key("k2"))); err != nil {
+		t.Fatalf("token of k2 after rotation: %v", err)
+	}
+}
+
+func TestJWKSRejectsHMAC(t *testing.T) {
+	srv := newJWKSServer(t, "k1")
+	v := newTestVerifier(t, config.Auth{Name: "idp", Type: config.AuthJWT, JWKSURL: srv.URL, JWKSRefresh: "1h"})
+
+	// an attacker knowing the public key signs an HS token with it
+	pub := x509.MarshalPKCS1PublicKey(&srv.key("k1").PublicKey)
+	for _, method := range []jwt.SigningMethod{jwt.SigningMethodHS256, jwt.SigningMethodHS384, jwt.SigningMethodHS512} {
+		raw := signToken(t, method, "k1", pub)
+		if _, err := v.verify(raw); err == nil {
+			t.Errorf("%s token was accepted by an RSA key set", method.Alg())
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/cunkz/goyummy/bin/config"
+	"github.com/cunkz/goyummy/bin/helpers/utils"
+)
+
+const principalKey = "principal"
+
+// Principal is the caller of a request, set by the auth middleware
+type Principal struct {
+	Auth    string         `json:"auth"`
+	Method  string         `json:"method"`
+	Subject string         `json:"subject"`
+	Roles   []string       `json:"roles,omitempty"`
+	Scopes  []string       `json:"scopes,omitempty"`
+	Claims  map[string]any `json:"claims,omitempty"`
+	// Via lists the auths that accepted the request when Auth is a composite
+	Via []string `json:"via,omitempty"`
+}
+
+func SetPrincipal(c *fiber.Ctx, p *Principal) {
+	c.Locals(principalKey, p)
+}
+
+// GetPrincipal returns the caller, nil on public routes
+func GetPrincipal(c *fiber.Ctx) *Principal {
+	p, _ := c.Locals(principalKey).(*Principal)
+	return p
+}
+
+func (p *Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// principalFromClaims reads subject, roles and scopes of a jwt auth
+func principalFromClaims(a config.Auth, claims map[string]any) *Principal {
+	p := &Principal{Auth: a.Name, Method: a.Type, Claims: claims}
+	if sub, ok := claims["sub"].(string); ok {
+		p.Subject = sub
+	}
+	if v, ok := ClaimValue(claims, a.RolesClaimPath()); ok {
+		p.Roles = claimStrings(v)
+	}
+	if v, ok := ClaimValue(claims, a.ScopesClaimPath()); ok {
+		p.Scopes = claimStrings(v)
+	}
+	return p
+}
+
+// ClaimValue returns the claim at a path like realm_access.roles
+func ClaimValue(claims map[string]any, path string) (any, bool) {
+	var v any = claims
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// SetClaimValue sets the claim at a path like realm_access.roles
+func SetClaimValue(claims map[string]any, path string, v any) {
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := claims[key].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			claims[key] = next
+		}
+		claims = next
+	}
+	claims[keys[len(keys)-1]] = v
+}
+
+// claimStrings accepts a list or a space separated string, the OAuth
+// format of the scope claim.
+func claimStrings(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			out = append(out, fmt.Sprint(item))
+		}
+		return out
+	case []string:
+		return v
+	}
+	return nil
+}
+
+// -------------------------------------
+// Requirements
+// -------------------------------------
+
+// Check returns why p does not meet req, "" when it does
+func Check(req config.Requirement, p *Principal) string {
+	if len(req.Roles) > 0 && !slices.ContainsFunc(req.Roles, p.HasRole) {
+		return "requires one of roles: " + strings.Join(req.Roles, ", ")
+	}
+	var missing []string
+	for _, scope := range req.Scope {
+		if !p.HasScope(scope) {
+			missing = append(missing, scope)
+		}
+	}
+	if len(missing) > 0 {
+		return "missing scope: " + strings.Join(missing, " ")
+	}
+	return ""
+}
+
+// Require runs after the auth middleware. A valid token without the
+// roles or scopes of req gets a 403.
+func Require(req config.Requirement) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		p := GetPrincipal(c)
+		if p == nil {
+			return utils.ResponseError(c, fiber.StatusUnauthorized, "authentication required")
+		}
+		if reason := Check(req, p); reason != "" {
+			return utils.ResponseError(c, fiber.StatusForbidden, "forbidden: "+reason)
+		}
+		return c.Next()
+	}
+}
@@ -21,14 +21,101 @@ import (
    INIT DBs: POSTGRES + MYSQL + MONGO
 ================================ */
 
-var (
-	PostgresDBs = make(map[string]*sql.DB)
-	MySQLDBs    = make(map[string]*sql.DB)
-	MongoDBs    = make(map[string]*mongo.Database)
-)
+// Registry holds the connections of one recipe. Handlers keep the registry
+// they were built with, a reload opens a new one and closes the old one
+// once its requests are done.
+type Registry struct {
+	Postgres map[string]*sql.DB
+	MySQL    map[string]*sql.DB
+	Mongo    map[string]*mongo.Database
+
+	clients []*mongo.Client
+	closers []func()
+}
+
+func newRegistry() *Registry {
+	return &Registry{
+		Postgres: make(map[string]*sql.DB),
+		MySQL:    make(map[string]*sql.DB),
+		Mongo:    make(map[string]*mongo.Database),
+	}
+}
 
-func InitDatabases(cfg *config.AppConfig) error {
+// SQL returns the pool of a postgres or mysql database, nil when the
+// registry or the database is missing
+func (r *Registry) SQL(engine, name string) *sql.DB {
+	if r == nil {
+		return nil
+	}
+	switch engine {
+	case "postgres":
+		return r.Postgres[name]
+	case "mysql":
+		return r.MySQL[name]
+	}
+	return nil
+}
+
+// MongoDB returns the database of a mongo entry, nil when missing
+func (r *Registry) MongoDB(name string) *mongo.Database {
+	if r == nil {
+		return nil
+	}
+	return r.Mongo[name]
+}
+
+// OnClose runs f when the registry is closed, for background work tied to
+// the recipe such as JWKS refreshes
+func (r *Registry) OnClose(f func()) {
+	if r != nil {
+		r.closers = append(r.closers, f)
+	}
+}
+
+// Ping checks every connection, connections are opened lazily otherwise
+func (r *Registry) Ping(ctx context.Context) error {
+	for name, conn := range r.Postgres {
+		if err := conn.PingContext(ctx); err != nil {
+			return fmt.Errorf("postgres (%s) error: %v", name, err)
+		}
+	}
+	for name, conn := range r.MySQL {
+		if err := conn.PingContext(ctx); err != nil {
+			return fmt.Errorf("mysql (%s) error: %v", name, err)
+		}
+	}
+	for name, mdb := range r.Mongo {
+		if err := mdb.Client().Ping(ctx, nil); err != nil {
+			return fmt.Errorf("mongo (%s) error: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Close stops the background work and closes every connection
+func (r *Registry) Close(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	for _, f := range r.closers {
+		f()
+	}
+	for _, conn := range r.Postgres {
+		_ = conn.Close()
+	}
+	for _, conn := range r.MySQL {
+		_ = conn.Close()
+	}
+	for _, client := range r.clients {
+		_ = client.Disconnect(ctx)
+	}
+}
+
+// InitDatabases opens the connections of every database of the recipe. The
+// registry is returned with the connections opened so far on error.
+func InitDatabases(cfg *config.AppConfig) (*Registry, error) {
 	ctx := context.Background()
+	reg := newRegistry()
 
 	for _, db := range cfg.Databases {
 		switch db.Engine {
@@ -39,7 +126,7 @@ func InitDatabases(cfg *config.AppConfig) error {
 		case "postgres", "mysql":
 			conn, err := sql.Open(db.Engine, db.URI)
 			if err != nil {
-				return fmt.Errorf("%s (%s) error: %v", db.Engine, db.Name, err)
+				return reg, fmt.Errorf("%s (%s) error: %v", db.Engine, db.Name, err)
 			}
 
 			// Set Pooling
@@ -53,9 +140,9 @@ func InitDatabases(cfg *config.AppConfig) error {
 
 			// Store based on engine
 			if db.Engine == "postgres" {
-				PostgresDBs[db.Name] = conn
+				reg.Postgres[db.Name] = conn
 			} else {
-				MySQLDBs[db.Name] = conn
+				reg.MySQL[db.Name] = conn
 			}
 
 		// -----------------------------------------------------
@@ -74,15 +161,16 @@ func InitDatabases(cfg *config.AppConfig) error {
 
 			client, err := mongo.Connect(ctx, opts)
 			if err != nil {
-				return fmt.Errorf("mongo (%s) error: %v", db.Name, err)
+				return reg, fmt.Errorf("mongo (%s) error: %v", db.Name, err)
 			}
 
 			u, _ := url.Parse(db.URI)
 			dbName := strings.TrimPrefix(u.Path, "/")
 
-			MongoDBs[db.Name] = client.Database(dbName)
+			reg.clients = append(reg.clients, client)
+			reg.Mongo[db.Name] = client.Database(dbName)
 		}
 	}
 
-	return nil
+	return reg, nil
 }
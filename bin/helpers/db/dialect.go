@@ -0,0 +1,317 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cunkz/goyummy/bin/config"
+)
+
+/* ===============================
+   SQL DIALECT: POSTGRES + MYSQL
+================================ */
+
+// Dialect hides the syntax differences between the supported SQL engines.
+type Dialect interface {
+	// Name returns the engine name as written in the recipe.
+	Name() string
+	// Placeholder returns the bind parameter for the n-th argument (1-based).
+	Placeholder(n int) string
+	// Quote quotes an identifier, keeping "schema.table" style names intact.
+	Quote(ident string) string
+	// SupportsReturning reports whether INSERT ... RETURNING is available.
+	SupportsReturning() bool
+	// LimitOffset returns the LIMIT/OFFSET clause, limit < 0 means no limit.
+	LimitOffset(limit, offset int) string
+	// ColumnType returns the column type used to create a field.
+	ColumnType(f config.Field) string
+	// TypeMatches reports whether a live information_schema data type
+	// can hold values of fieldType.
+	TypeMatches(fieldType, dataType string) bool
+	// ColumnsQuery lists column_name, data_type, is_nullable of a table.
+	ColumnsQuery(table string) (string, []any)
+	// DefaultSQL returns the DEFAULT expression of a field, "" when it
+	// has none.
+	DefaultSQL(f config.Field) string
+}
+
+func GetDialect(engine string) Dialect {
+	switch engine {
+	case "postgres":
+		return postgresDialect{}
+	case "mysql":
+		return mysqlDialect{}
+	}
+	return nil
+}
+
+// -----------------------------------------------------
+// POSTGRES
+// -----------------------------------------------------
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string { return "postgres" }
+
+func (postgresDialect) Placeholder(n int) string { return fmt.Sprintf("$%d", n) }
+
+func (postgresDialect) Quote(ident string) string { return quoteIdent(ident, `"`) }
+
+func (postgresDialect) SupportsReturning() bool { return true }
+
+func (postgresDialect) LimitOffset(limit, offset int) string {
+	clause := ""
+	if limit >= 0 {
+		clause = fmt.Sprintf(" LIMIT %d", limit)
+	}
+	if offset > 0 {
+		clause += fmt.Sprintf(" OFFSET %d", offset)
+	}
+	return clause
+}
+
+var postgresTypes = map[string]string{
+	config.FieldString:    "TEXT",
+	config.FieldInt:       "BIGINT",
+	config.FieldFloat:     "DOUBLE PRECISION",
+	config.FieldBool:      "BOOLEAN",
+	config.FieldTimestamp: "TIMESTAMPTZ",
+	config.FieldJSON:      "JSONB",
+	config.FieldUUID:      "UUID",
+	config.FieldDecimal:   "NUMERIC",
+}
+
+var postgresDataTypes = map[string][]string{
+	config.FieldString:    {"text", "character varying", "character"},
+	config.FieldInt:       {"bigint", "integer", "smallint"},
+	config.FieldFloat:     {"double precision", "real", "numeric"},
+	config.FieldBool:      {"boolean"},
+	config.FieldTimestamp: {"timestamp with time zone", "timestamp without time zone", "date"},
+	config.FieldJSON:      {"jsonb", "json"},
+	config.FieldUUID:      {"uuid", "text", "character varying", "character"},
+	config.FieldDecimal:   {"numeric"},
+}
+
+func (postgresDialect) ColumnType(f config.Field) string {
+	if t, ok := postgresTypes[f.Type]; ok {
+		return t
+	}
+	return postgresTypes[config.FieldString]
+}
+
+func (postgresDialect) TypeMatches(fieldType, dataType string) bool {
+	return slices.Contains(postgresDataTypes[fieldType], strings.ToLower(dataType))
+}
+
+func (postgresDialect) ColumnsQuery(table string) (string, []any) {
+	schema, name := splitTable(table)
+	query := "SELECT column_name, data_type, is_nullable FROM information_schema.columns WHERE table_name = $1 AND table_schema = "
+	if schema == "" {
+		return query + "current_schema()", []any{name}
+	}
+	return query + "$2", []any{name, schema}
+}
+
+func (postgresDialect) DefaultSQL(f config.Field) string {
+	switch dynamicDefault(f) {
+	case "now":
+		return "now()"
+	case "random":
+		return "gen_random_uuid()"
+	}
+	lit, ok := sqlLiteral(f, false)
+	if !ok {
+		return ""
+	}
+	return lit
+}
+
+// -----------------------------------------------------
+// MYSQL
+// -----------------------------------------------------
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string { return "mysql" }
+
+func (mysqlDialect) Placeholder(int) string { return "?" }
+
+func (mysqlDialect) Quote(ident string) string { return quoteIdent(ident, "`") }
+
+func (mysqlDialect) SupportsReturning() bool { return false }
+
+func (mysqlDialect) LimitOffset(limit, offset int) string {
+	// MySQL has no OFFSET without LIMIT, use the documented max value instead
+	if limit < 0 && offset > 0 {
+		return fmt.Sprintf(" LIMIT 18446744073709551615 OFFSET %d", offset)
+	}
+	clause := ""
+	if limit >= 0 {
+		clause = fmt.Sprintf(" LIMIT %d", limit)
+	}
+	if offset > 0 {
+		clause += fmt.Sprintf(" OFFSET %d", offset)
+	}
+	return clause
+}
+
+var mysqlTypes = map[string]string{
+	config.FieldString:    "TEXT",
+	config.FieldInt:       "BIGINT",
+	config.FieldFloat:     "DOUBLE",
+	config.FieldBool:      "BOOLEAN",
+	config.FieldTimestamp: "DATETIME(6)",
+	config.FieldJSON:      "JSON",
+	config.FieldUUID:      "CHAR(36)",
+	config.FieldDecimal:   "DECIMAL(38,10)",
+}
+
+var mysqlDataTypes = map[string][]string{
+	config.FieldString:    {"text", "mediumtext", "longtext", "tinytext", "varchar", "char"},
+	config.FieldInt:       {"bigint", "int", "mediumint", "smallint", "tinyint"},
+	config.FieldFloat:     {"double", "float", "decimal"},
+	config.FieldBool:      {"tinyint", "bit"},
+	config.FieldTimestamp: {"datetime", "timestamp", "date"},
+	config.FieldJSON:      {"json", "longtext"},
+	config.FieldUUID:      {"char", "varchar", "binary"},
+	config.FieldDecimal:   {"decimal"},
+}
+
+func (mysqlDialect) ColumnType(f config.Field) string {
+	// TEXT cannot be indexed, bounded strings get a VARCHAR
+	// a hash is longer than the plain value max_length is about
+	if f.Type == config.FieldString && f.Hash == "" && f.Validate.MaxLength != nil && *f.Validate.MaxLength <= 16383 {
+		return fmt.Sprintf("VARCHAR(%d)", *f.Validate.MaxLength)
+	}
+	if t, ok := mysqlTypes[f.Type]; ok {
+		return t
+	}
+	return mysqlTypes[config.FieldString]
+}
+
+func (mysqlDialect) TypeMatches(fieldType, dataType string) bool {
+	return slices.Contains(mysqlDataTypes[fieldType], strings.ToLower(dataType))
+}
+
+func (mysqlDialect) ColumnsQuery(table string) (string, []any) {
+	schema, name := splitTable(table)
+	query := "SELECT column_name, data_type, is_nullable FROM information_schema.columns WHERE table_name = ? AND table_schema = "
+	if schema == "" {
+		return query + "DATABASE()", []any{name}
+	}
+	return query + "?", []any{name, schema}
+}
+
+func (d mysqlDialect) DefaultSQL(f config.Field) string {
+	switch dynamicDefault(f) {
+	case "now":
+		return "CURRENT_TIMESTAMP(6)"
+	case "random":
+		return "(UUID())"
+	}
+	lit, ok := sqlLiteral(f, true)
+	if !ok {
+		return ""
+	}
+	// TEXT and JSON columns only take an expression default
+	if t := d.ColumnType(f); t == "TEXT" || t == "JSON" {
+		return "(" + lit + ")"
+	}
+	return lit
+}
+
+// -----------------------------------------------------
+
+// dynamicDefault tells the defaults computed on insert, now for
+// timestamps and random for uuids
+func dynamicDefault(f config.Field) string {
+	s, _ := f.Default.(string)
+	switch {
+	case f.Type == config.FieldTimestamp && strings.EqualFold(s, "now"):
+		return "now"
+	case f.Type == config.FieldUUID && strings.EqualFold(s, "random"):
+		return "random"
+	}
+	return ""
+}
+
+// sqlLiteral writes the default of f as a SQL literal. Hashed fields have
+// none, the column would hold the plain value. MySQL also escapes
+// backslashes in strings.
+func sqlLiteral(f config.Field, backslash bool) (string, bool) {
+	if f.Default == nil || f.Hash != "" {
+		return "", false
+	}
+	var s string
+	switch v := f.Default.(type) {
+	case bool:
+		if f.Type == config.FieldBool {
+			return strings.ToUpper(strconv.FormatBool(v)), true
+		}
+		s = strconv.FormatBool(v)
+	case int:
+		if f.Type == config.FieldInt || f.Type == config.FieldFloat || f.Type == config.FieldDecimal {
+			return strconv.Itoa(v), true
+		}
+		s = strconv.Itoa(v)
+	case float64:
+		if f.Type == config.FieldFloat || f.Type == config.FieldDecimal {
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		}
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		s = v
+	default:
+		if f.Type != config.FieldJSON {
+			return "", false
+		}
+	}
+	if f.Type == config.FieldJSON {
+		b, err := json.Marshal(f.Default)
+		if err != nil {
+			return "", false
+		}
+		s = string(b)
+	}
+	if backslash {
+		s = strings.ReplaceAll(s, `\`, `\\`)
+	}
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'", true
+}
+
+// splitTable splits "schema.table" into its parts
+func splitTable(table string) (string, string) {
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		return table[:i], table[i+1:]
+	}
+	return "", table
+}
+
+func quoteIdent(ident, q string) string {
+	parts := strings.Split(ident, ".")
+	for i, p := range parts {
+		parts[i] = q + strings.ReplaceAll(p, q, q+q) + q
+	}
+	return strings.Join(parts, ".")
+}
+
+// Placeholders returns the bind parameters for count arguments starting at start.
+func Placeholders(d Dialect, start, count int) []string {
+	out := make([]string, count)
+	for i := range out {
+		out[i] = d.Placeholder(start + i)
+	}
+	return out
+}
+
+// QuoteAll quotes every identifier in idents.
+func QuoteAll(d Dialect, idents []string) []string {
+	out := make([]string, len(idents))
+	for i, ident := range idents {
+		out[i] = d.Quote(ident)
+	}
+	return out
+}
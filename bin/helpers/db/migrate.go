@@ -0,0 +1,394 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/cunkz/goyummy/bin/config"
+)
+
+/* ===============================
+   MIGRATE: TABLES FROM THE RECIPE
+================================ */
+
+// TableSpec is a table (or collection) expected by the recipe
+type TableSpec struct {
+	Name   string
+	Fields []config.Field
+}
+
+// Migrate applies the migrate mode of every database. In verify mode all
+// drifts are collected and returned together.
+func Migrate(cfg *config.AppConfig, reg *Registry) error {
+	ctx := context.Background()
+	var problems []string
+
+	for _, d := range cfg.Databases {
+		mode := strings.ToLower(d.Migrate)
+		if mode == "" || mode == config.MigrateOff {
+			continue
+		}
+
+		tables := TablesFor(cfg, d.Name)
+		log.Info().Msgf("Migrate %s (%s): %s, %d table(s)", d.Name, d.Engine, mode, len(tables))
+
+		var err error
+		switch d.Engine {
+		case "postgres", "mysql":
+			conn := reg.SQL(d.Engine, d.Name)
+			if conn == nil {
+				return fmt.Errorf("migrate %s: database is not connected", d.Name)
+			}
+			// a missing schema is reported as missing tables in verify mode
+			if mode == config.MigrateAuto {
+				for _, schema := range TenantSchemas(cfg, d.Name) {
+					if _, err := conn.ExecContext(ctx, "CREATE SCHEMA IF NOT EXISTS "+GetDialect(d.Engine).Quote(schema)); err != nil {
+						return fmt.Errorf("migrate %s: create schema %s: %w", d.Name, schema, err)
+					}
+				}
+			}
+			err = migrateSQL(ctx, conn, GetDialect(d.Engine), tables, mode == config.MigrateVerify)
+		case "mongo":
+			mdb := reg.MongoDB(d.Name)
+			if mdb == nil {
+				return fmt.Errorf("migrate %s: database is not connected", d.Name)
+			}
+			err = migrateMongo(ctx, mdb, tables, mode == config.MigrateVerify)
+		}
+
+		var drift driftError
+		if errors.As(err, &drift) {
+			for _, p := range drift {
+				problems = append(problems, d.Name+": "+p)
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("migrate %s: %w", d.Name, err)
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("schema drift from recipe:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+// TablesFor returns the tables of the modules stored in database name.
+// Modules sharing a table have their fields merged. Token denylists and
+// api key stores are tables too.
+func TablesFor(cfg *config.AppConfig, name string) []TableSpec {
+	var tables []TableSpec
+	index := map[string]int{}
+
+	for _, m := range cfg.Modules {
+		if !storedIn(cfg, m, name) {
+			continue
+		}
+		fields := m.Fields
+		if t := cfg.Tenancy; t.Scopes(m) && t.Mode == config.TenancyColumn {
+			fields = append([]config.Field{{Name: t.ColumnName(), Type: config.FieldString}}, fields...)
+		}
+		for _, table := range tableNames(cfg, m) {
+			i, ok := index[table]
+			if !ok {
+				i = len(tables)
+				index[table] = i
+				tables = append(tables, TableSpec{Name: table, Fields: append([]config.Field{}, config.SystemFields...)})
+			}
+			for _, f := range fields {
+				if !hasField(tables[i].Fields, f.Name) {
+					tables[i].Fields = append(tables[i].Fields, f)
+				}
+			}
+		}
+	}
+
+	// denylists of the jwt auths issuing tokens and api key stores
+	for _, a := range cfg.Auths {
+		if a.JWTIssue != nil && a.JWTIssue.Denylist.Database == name {
+			tables = append(tables, authTable(a.JWTIssue.Denylist.Table, config.DenylistFields))
+		}
+		if a.APIKeyStore != nil && a.APIKeyStore.Database == name {
+			tables = append(tables, authTable(a.APIKeyStore.Table, config.APIKeyFields))
+		}
+	}
+
+	return tables
+}
+
+// tableNames are the tables of m: one per listed tenant with a schema
+// per tenant, schema.table
+func tableNames(cfg *config.AppConfig, m config.Module) []string {
+	t := cfg.Tenancy
+	if !t.Scopes(m) || t.Mode != config.TenancySchema {
+		return []string{m.Table}
+	}
+	names := make([]string, len(t.Tenants))
+	for i, tenant := range t.Tenants {
+		names[i] = t.SchemaFor(tenant) + "." + m.Table
+	}
+	return names
+}
+
+// TenantSchemas returns the schemas of the listed tenants holding tables
+// of database name, empty outside schema tenancy
+func TenantSchemas(cfg *config.AppConfig, name string) []string {
+	t := cfg.Tenancy
+	if t == nil || t.Mode != config.TenancySchema {
+		return nil
+	}
+	scoped := slices.ContainsFunc(cfg.Modules, func(m config.Module) bool { return m.Database == name && t.Scopes(m) })
+	if !scoped {
+		return nil
+	}
+	schemas := make([]string, len(t.Tenants))
+	for i, tenant := range t.Tenants {
+		schemas[i] = t.SchemaFor(tenant)
+	}
+	return schemas
+}
+
+// storedIn tells if the table of m is in database name. With a database
+// per tenant, the databases of the listed tenants hold it too.
+func storedIn(cfg *config.AppConfig, m config.Module, name string) bool {
+	if m.Database == name {
+		return true
+	}
+	t := cfg.Tenancy
+	if !t.Scopes(m) || t.Mode != config.TenancyDatabase {
+		return false
+	}
+	for _, tenant := range t.Tenants {
+		if t.DatabaseFor(m.Database, tenant) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func authTable(name string, fields []config.Field) TableSpec {
+	all := append([]config.Field{}, config.SystemFields...)
+	return TableSpec{Name: name, Fields: append(all, fields...)}
+}
+
+func hasField(fields []config.Field, name string) bool {
+	for _, f := range fields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+type driftError []string
+
+func (e driftError) Error() string { return strings.Join(e, "; ") }
+
+// -----------------------------------------------------
+// POSTGRES + MYSQL
+// -----------------------------------------------------
+
+type liveColumn struct {
+	dataType string
+	nullable bool
+}
+
+func migrateSQL(ctx context.Context, conn *sql.DB, d Dialect, tables []TableSpec, verify bool) error {
+	var drift driftError
+
+	for _, t := range tables {
+		live, err := liveColumns(ctx, conn, d, t.Name)
+		if err != nil {
+			return err
+		}
+
+		if len(live) == 0 {
+			if verify {
+				drift = append(drift, fmt.Sprintf("table %s is missing", t.Name))
+				continue
+			}
+			if _, err := conn.ExecContext(ctx, createTableSQL(d, t)); err != nil {
+				return fmt.Errorf("create table %s: %w", t.Name, err)
+			}
+			log.Info().Msgf("Created table %s", t.Name)
+			continue
+		}
+
+		for _, f := range t.Fields {
+			col, ok := live[strings.ToLower(f.Name)]
+			if !ok {
+				if verify {
+					drift = append(drift, fmt.Sprintf("column %s.%s is missing", t.Name, f.Name))
+					continue
+				}
+				// the rows already there need a value, without a default
+				// the column can only be added nullable
+				added := f
+				if !isNullable(f) && d.DefaultSQL(f) == "" {
+					added.Nullable = true
+				}
+				query := "ALTER TABLE " + d.Quote(t.Name) + " ADD COLUMN " + columnSQL(d, added)
+				if _, err := conn.ExecContext(ctx, query); err != nil {
+					return fmt.Errorf("add column %s.%s: %w", t.Name, f.Name, err)
+				}
+				if added.Nullable != f.Nullable {
+					log.Warn().Msgf("Added column %s.%s as nullable, it has no default for the existing rows: fill it, then set it NOT NULL", t.Name, f.Name)
+				} else {
+					log.Info().Msgf("Added column %s.%s", t.Name, f.Name)
+				}
+				continue
+			}
+
+			// existing columns are never altered, only reported
+			if !d.TypeMatches(f.Type, col.dataType) {
+				msg := fmt.Sprintf("column %s.%s is %s, recipe expects %s", t.Name, f.Name, col.dataType, f.Type)
+				if verify {
+					drift = append(drift, msg)
+				} else {
+					log.Warn().Msg(msg)
+				}
+			}
+			if col.nullable != isNullable(f) && verify {
+				drift = append(drift, fmt.Sprintf("column %s.%s nullable is %t, recipe expects %t", t.Name, f.Name, col.nullable, isNullable(f)))
+			}
+		}
+	}
+
+	if len(drift) > 0 {
+		return drift
+	}
+	return nil
+}
+
+func liveColumns(ctx context.Context, conn *sql.DB, d Dialect, table string) (map[string]liveColumn, error) {
+	query, args := d.ColumnsQuery(table)
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("inspect table %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	cols := map[string]liveColumn{}
+	for rows.Next() {
+		var name, dataType, nullable string
+		if err := rows.Scan(&name, &dataType, &nullable); err != nil {
+			return nil, err
+		}
+		cols[strings.ToLower(name)] = liveColumn{dataType: dataType, nullable: strings.EqualFold(nullable, "YES")}
+	}
+	return cols, rows.Err()
+}
+
+// system fields are never null, the id is the primary key
+func isNullable(f config.Field) bool {
+	return f.Nullable && !hasField(config.SystemFields, f.Name)
+}
+
+func columnSQL(d Dialect, f config.Field) string {
+	col := d.Quote(f.Name) + " " + d.ColumnType(f)
+	if def := d.DefaultSQL(f); def != "" {
+		col += " DEFAULT " + def
+	}
+	if !isNullable(f) {
+		col += " NOT NULL"
+	}
+	if f.Name == "id" {
+		col += " PRIMARY KEY"
+	}
+	return col
+}
+
+func createTableSQL(d Dialect, t TableSpec) string {
+	cols := make([]string, len(t.Fields))
+	for i, f := range t.Fields {
+		cols[i] = columnSQL(d, f)
+	}
+	return "CREATE TABLE IF NOT EXISTS " + d.Quote(t.Name) + " (" + strings.Join(cols, ", ") + ")"
+}
+
+// -----------------------------------------------------
+// MONGO DB
+// -----------------------------------------------------
+
+func migrateMongo(ctx context.Context, mdb *mongo.Database, tables []TableSpec, verify bool) error {
+	var drift driftError
+
+	existing, err := mdb.ListCollectionNames(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	exists := map[string]bool{}
+	for _, name := range existing {
+		exists[name] = true
+	}
+
+	for _, t := range tables {
+		if !exists[t.Name] {
+			if verify {
+				drift = append(drift, fmt.Sprintf("collection %s is missing", t.Name))
+				continue
+			}
+			if err := mdb.CreateCollection(ctx, t.Name); err != nil {
+				return fmt.Errorf("create collection %s: %w", t.Name, err)
+			}
+			log.Info().Msgf("Created collection %s", t.Name)
+		}
+
+		col := mdb.Collection(t.Name)
+		if verify {
+			ok, err := hasUniqueIDIndex(ctx, col)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				drift = append(drift, fmt.Sprintf("collection %s has no unique index on id", t.Name))
+			}
+			continue
+		}
+
+		// creating an existing index is a no-op
+		_, err := col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "created_at", Value: 1}, {Key: "id", Value: 1}}},
+		})
+		if err != nil {
+			return fmt.Errorf("create indexes on %s: %w", t.Name, err)
+		}
+	}
+
+	if len(drift) > 0 {
+		return drift
+	}
+	return nil
+}
+
+func hasUniqueIDIndex(ctx context.Context, col *mongo.Collection) (bool, error) {
+	cursor, err := col.Indexes().List(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer cursor.Close(ctx)
+
+	var indexes []bson.M
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return false, err
+	}
+	for _, idx := range indexes {
+		key, _ := idx["key"].(bson.M)
+		unique, _ := idx["unique"].(bool)
+		if unique && len(key) == 1 && key["id"] != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/* ===============================
+   TIMESTAMPS FROM ANY DRIVER
+================================ */
+
+// Layouts of timestamps written as text: JSON bodies, and MySQL DATETIME
+// columns read without parseTime=true in the DSN
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// ParseTimestamp reads a timestamp in any of the layouts above
+func ParseTimestamp(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	var err error
+	for _, layout := range timestampLayouts {
+		var t time.Time
+		if t, err = time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// NullTimestamp scans a nullable timestamp column returned as a time or
+// as text, sql.NullTime only takes the former.
+type NullTimestamp struct {
+	Time  time.Time
+	Valid bool
+}
+
+func (n *NullTimestamp) Scan(src any) error {
+	*n = NullTimestamp{}
+	switch t := src.(type) {
+	case nil:
+		return nil
+	case time.Time:
+		n.Time = t
+	case []byte:
+		return n.Scan(string(t))
+	case string:
+		ts, err := ParseTimestamp(t)
+		if err != nil {
+			return err
+		}
+		n.Time = ts
+	default:
+		return fmt.Errorf("cannot scan %T as timestamp", src)
+	}
+	n.Valid = true
+	return nil
+}
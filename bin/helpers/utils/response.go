@@ -7,6 +7,7 @@ type JSONResponse struct {
 	Code    int         `json:"code"`
 	Data    interface{} `json:"data"`
 	Message string      `json:"message"`
+	Meta    interface{} `json:"meta,omitempty"`
 }
 
 func ResponseSuccess(c *fiber.Ctx, data interface{}, message string) error {
@@ -19,6 +20,17 @@ func ResponseSuccess(c *fiber.Ctx, data interface{}, message string) error {
 	return c.Status(fiber.StatusOK).JSON(resp)
 }
 
+func ResponseSuccessMeta(c *fiber.Ctx, data interface{}, meta interface{}, message string) error {
+	resp := JSONResponse{
+		Status:  true,
+		Code:    fiber.StatusOK,
+		Data:    data,
+		Message: message,
+		Meta:    meta,
+	}
+	return c.Status(fiber.StatusOK).JSON(resp)
+}
+
 func ResponseError(c *fiber.Ctx, code int, message string) error {
 	resp := JSONResponse{
 		Status:  false,
@@ -28,3 +40,13 @@ func ResponseError(c *fiber.Ctx, code int, message string) error {
 	}
 	return c.Status(code).JSON(resp)
 }
+
+func ResponseErrorData(c *fiber.Ctx, code int, data interface{}, message string) error {
+	resp := JSONResponse{
+		Status:  false,
+		Code:    code,
+		Data:    data,
+		Message: message,
+	}
+	return c.Status(code).JSON(resp)
+}
@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	recipe "github.com/cunkz/goyummy/bin/config"
+)
+
+// SchemaPath serves the JSON Schema of the recipe format
+const SchemaPath = "/recipe.schema.json"
+
+func RegisterSchemaRoute(app *fiber.App) {
+
+	// JSON Schema of recipes, for editors and CI
+	app.Get(SchemaPath, func(c *fiber.Ctx) error {
+		if err := c.JSON(recipe.RecipeSchema()); err != nil {
+			return err
+		}
+		c.Set(fiber.HeaderContentType, "application/schema+json")
+		return nil
+	})
+}
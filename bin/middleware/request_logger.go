@@ -5,6 +5,8 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/rs/zerolog/log"
+
+	"github.com/cunkz/goyummy/bin/helpers/auth"
 )
 
 func RequestLogger() fiber.Handler {
@@ -13,12 +15,21 @@ func RequestLogger() fiber.Handler {
 
 		err := c.Next()
 
-		log.Info().
+		event := log.Info().
 			Str("method", c.Method()).
 			Str("path", c.Path()).
 			Int("status", c.Response().StatusCode()).
-			Dur("duration", time.Since(start)).
-			Msg("request")
+			Dur("duration", time.Since(start))
+
+		// who made the request and which auth accepted it
+		if p := auth.GetPrincipal(c); p != nil {
+			event = event.Str("auth", p.Auth).Str("auth_method", p.Method).Str("subject", p.Subject)
+			if len(p.Via) > 0 {
+				event = event.Strs("auth_via", p.Via)
+			}
+		}
+
+		event.Msg("request")
 
 		return err
 	}
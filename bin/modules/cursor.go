@@ -0,0 +1,180 @@
+package modules
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/cunkz/goyummy/bin/config"
+)
+
+// Used to sign cursors of modules without cursor_secret. Cursors signed
+// with it stop being valid when the process restarts.
+var processCursorSecret = func() []byte {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return b
+}()
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// cursorPosition is the sort key and id of the last row of a page
+type cursorPosition struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d,omitempty"`
+	Value any    `json:"v"`
+	ID    string `json:"id"`
+}
+
+type cursorMeta struct {
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+}
+
+func cursorSecret(pg config.Pagination) []byte {
+	if pg.CursorSecret != "" {
+		return []byte(pg.CursorSecret)
+	}
+	return processCursorSecret
+}
+
+func signCursor(secret []byte, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func encodeCursor(secret []byte, pos cursorPosition) (string, error) {
+	payload, err := json.Marshal(pos)
+	if err != nil {
+		return "", err
+	}
+	return signCursor(secret, payload), nil
+}
+
+// decodeCursor verifies the signature and restores the sort value with
+// the type of the sort field.
+func decodeCursor(secret []byte, s string, sortField config.Field) (*cursorPosition, error) {
+	payloadPart, sigPart, ok := strings.Cut(s, ".")
+	if !ok {
+		return nil, errInvalidCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(payloadPart)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sigPart)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errInvalidCursor
+	}
+
+	var pos cursorPosition
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&pos); err != nil {
+		return nil, errInvalidCursor
+	}
+	if pos.Sort != sortField.Name {
+		return nil, fmt.Errorf("cursor was issued for sort %s", pos.Sort)
+	}
+
+	v, err := coerceValue(sortField, pos.Value)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	pos.Value = v
+	return &pos, nil
+}
+
+// cursorPage trims the extra row fetched to detect a next page and
+// builds the cursor pointing after the last returned row. The sort key is
+// removed afterwards when it was read only for the cursor.
+func cursorPage(secret []byte, p listParams, list []map[string]any, selected []config.Field) ([]map[string]any, cursorMeta, error) {
+	meta := cursorMeta{Limit: p.Limit}
+	if len(withField(selected, p.SortField)) != len(selected) {
+		defer func() {
+			for _, item := range list {
+				delete(item, p.SortField.Name)
+			}
+		}()
+	}
+	if len(list) <= p.Limit {
+		return list, meta, nil
+	}
+
+	list = list[:p.Limit]
+	last := list[len(list)-1]
+	key := p.Sort[0]
+
+	next, err := encodeCursor(secret, cursorPosition{
+		Sort:  key.Name,
+		Desc:  key.Desc,
+		Value: last[key.Name],
+		ID:    fmt.Sprint(last["id"]),
+	})
+	if err != nil {
+		return nil, meta, err
+	}
+
+	meta.NextCursor = next
+	meta.HasMore = true
+	return list, meta, nil
+}
+
+// -------------------------------------
+// SQL
+// -------------------------------------
+
+// addKeyset restricts rows to the ones after pos, (sort, id) > (v, id)
+func (w *sqlWhere) addKeyset(f config.Field, pos *cursorPosition) {
+	cmp := ">"
+	if pos.Desc {
+		cmp = "<"
+	}
+	idCol := w.dialect.Quote("id")
+
+	if f.Name == "id" {
+		w.add(idCol + " " + cmp + " " + w.arg(pos.ID))
+		return
+	}
+
+	col := w.dialect.Quote(f.Name)
+	w.add("(" + col + ", " + idCol + ") " + cmp + " (" + w.arg(sqlValue(f, pos.Value)) + ", " + w.arg(pos.ID) + ")")
+}
+
+// -------------------------------------
+// MONGO
+// -------------------------------------
+
+func mongoKeyset(f config.Field, pos *cursorPosition) bson.M {
+	cmp := "$gt"
+	if pos.Desc {
+		cmp = "$lt"
+	}
+
+	if f.Name == "id" {
+		return bson.M{"id": bson.M{cmp: pos.ID}}
+	}
+
+	v := mongoValue(f, pos.Value)
+	return bson.M{"$or": []bson.M{
+		{f.Name: bson.M{cmp: v}},
+		{f.Name: v, "id": bson.M{cmp: pos.ID}},
+	}}
+}
@@ -0,0 +1,145 @@
+package modules
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cunkz/goyummy/bin/config"
+	"github.com/cunkz/goyummy/bin/helpers/db"
+)
+
+var cursorKey = []byte("cursor-secret")
+
+func TestCursorRoundTrip(t *testing.T) {
+	at := time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		field config.Field
+		value any
+		want  any
+	}{
+		{config.Field{Name: "stock", Type: config.FieldInt}, int64(42), int64(42)},
+		{config.Field{Name: "name", Type: config.FieldString}, "pen", "pen"},
+		{config.Field{Name: "price", Type: config.FieldDecimal}, "10.50", "10.50"},
+		{config.Field{Name: "created_at", Type: config.FieldTimestamp}, at, at},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field.Type, func(t *testing.T) {
+			s, err := encodeCursor(cursorKey, cursorPosition{Sort: tt.field.Name, Desc: true, Value: tt.value, ID: "row-1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			pos, err := decodeCursor(cursorKey, s, tt.field)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if got, ok := pos.Value.(time.Time); ok {
+				if !got.Equal(tt.want.(time.Time)) {
+					t.Errorf("value = %v, want %v", got, tt.want)
+				}
+			} else if pos.Value != tt.want {
+				t.Errorf("value = %#v, want %#v", pos.Value, tt.want)
+			}
+			if pos.ID != "row-1" || !pos.Desc || pos.Sort != tt.field.Name {
+				t.Errorf("position = %+v", pos)
+			}
+		})
+	}
+}
+
+func TestCursorTampering(t *testing.T) {
+	stock := config.Field{Name: "stock", Type: config.FieldInt}
+	valid, err := encodeCursor(cursorKey, cursorPosition{Sort: "stock", Value: int64(5), ID: "row-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, sig, _ := strings.Cut(valid, ".")
+
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"s":"stock","v":1000,"id":"row-1"}`))
+	flipped := []byte(sig)
+	flipped[0] ^= 1
+
+	tests := []struct {
+		name   string
+		cursor string
+		secret []byte
+	}{
+		{"payload changed", forged + "." + sig, cursorKey},
+		{"signature changed", payload + "." + string(flipped), cursorKey},
+		{"signature missing", payload, cursorKey},
+		{"signature empty", payload + ".", cursorKey},
+		{"other secret", valid, []byte("other-secret")},
+		{"not base64", "!!!.???", cursorKey},
+		{"empty", "", cursorKey},
+		{"not json", signCursor(cursorKey, []byte("not json")), cursorKey},
+		{"value of another type", signCursor(cursorKey, []byte(`{"s":"stock","v":"many","id":"row-1"}`)), cursorKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeCursor(tt.secret, tt.cursor, stock); err != errInvalidCursor {
+				t.Errorf("error = %v, want %v", err, errInvalidCursor)
+			}
+		})
+	}
+
+	t.Run("other sort", func(t *testing.T) {
+		_, err := decodeCursor(cursorKey, valid, config.Field{Name: "name", Type: config.FieldString})
+		if err == nil || err.Error() != "cursor was issued for sort stock" {
+			t.Errorf("error = %v", err)
+		}
+	})
+}
+
+func TestCursorPage(t *testing.T) {
+	stock := config.Field{Name: "stock", Type: config.FieldInt}
+	p := listParams{Limit: 2, Cursor: true, SortField: stock, Sort: []sortField{{Name: "stock"}, {Name: "id"}}}
+	rows := func() []map[string]any {
+		return []map[string]any{
+			{"id": "a", "stock": int64(1)},
+			{"id": "b", "stock": int64(2)},
+			{"id": "c", "stock": int64(3)},
+		}
+	}
+
+	list, meta, err := cursorPage(cursorKey, p, rows(), []config.Field{{Name: "id"}, stock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || !meta.HasMore || meta.NextCursor == "" {
+		t.Fatalf("page = %v, meta = %+v", list, meta)
+	}
+	pos, err := decodeCursor(cursorKey, meta.NextCursor, stock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos.ID != "b" || pos.Value != int64(2) {
+		t.Errorf("next cursor points at %+v, want row b", pos)
+	}
+
+	// the sort key only read for the cursor is not returned
+	list, _, err = cursorPage(cursorKey, p, rows(), []config.Field{{Name: "id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := list[0]["stock"]; ok {
+		t.Errorf("sort key left in %v", list[0])
+	}
+
+	// last page
+	_, meta, err = cursorPage(cursorKey, p, rows()[:2], []config.Field{{Name: "id"}, stock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.HasMore || meta.NextCursor != "" {
+		t.Errorf("last page meta = %+v", meta)
+	}
+}
+
+func TestSQLKeyset(t *testing.T) {
+	w := newSQLWhere(db.GetDialect("postgres"))
+	w.addKeyset(config.Field{Name: "stock", Type: config.FieldInt}, &cursorPosition{Value: int64(2), ID: "b", Desc: true})
+	if got, want := w.clause(), ` WHERE ("stock", "id") < ($1, $2)`; got != want {
+		t.Errorf("clause = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,495 @@
+package modules
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/cunkz/goyummy/bin/config"
+	"github.com/cunkz/goyummy/bin/helpers/db"
+)
+
+// decimalRe is a plain decimal, the form every engine reads: no exponent,
+// fraction or base prefix
+var decimalRe = regexp.MustCompile(`^[+-]?\d+(\.\d+)?$`)
+
+// allFields returns the readable fields followed by the system fields.
+// Hashed fields are write-only and left out.
+func allFields(m config.Module) []config.Field {
+	fields := make([]config.Field, 0, len(m.Fields)+len(config.SystemFields))
+	for _, f := range m.Fields {
+		if f.Hash == "" {
+			fields = append(fields, f)
+		}
+	}
+	return append(fields, config.SystemFields...)
+}
+
+func fieldNames(fields []config.Field) []string {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// -------------------------------------
+// Errors
+// -------------------------------------
+
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type fieldErrors []fieldError
+
+func (e fieldErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// -------------------------------------
+// Request body
+// -------------------------------------
+
+// parseBody reads the request body keeping JSON numbers untouched, so
+// int and decimal fields do not lose precision through float64.
+func parseBody(c *fiber.Ctx) (map[string]any, error) {
+	body := map[string]any{}
+	if len(c.Body()) == 0 {
+		return body, nil
+	}
+
+	if strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEApplicationJSON) {
+		dec := json.NewDecoder(bytes.NewReader(c.Body()))
+		dec.UseNumber()
+		if err := dec.Decode(&body); err != nil {
+			return nil, err
+		}
+		return body, nil
+	}
+
+	form := map[string]string{}
+	if err := c.BodyParser(&form); err != nil {
+		return nil, err
+	}
+	for k, v := range form {
+		body[k] = v
+	}
+	return body, nil
+}
+
+// bodyValues coerces the configured fields found in body. When
+// withDefaults is set, missing fields get their configured default.
+func bodyValues(fields []config.Field, body map[string]any, withDefaults bool) (map[string]any, fieldErrors) {
+	values := map[string]any{}
+	var errs fieldErrors
+
+	for _, f := range fields {
+		raw, ok := body[f.Name]
+		if !ok {
+			if withDefaults && f.Default != nil {
+				v, err := defaultValue(f)
+				if err != nil {
+					errs = append(errs, fieldError{f.Name, err.Error()})
+					continue
+				}
+				values[f.Name] = v
+			}
+			continue
+		}
+
+		v, err := coerceValue(f, raw)
+		if err != nil {
+			errs = append(errs, fieldError{f.Name, err.Error()})
+			continue
+		}
+		values[f.Name] = v
+	}
+
+	return values, errs
+}
+
+func defaultValue(f config.Field) (any, error) {
+	if s, ok := f.Default.(string); ok && f.Type == config.FieldTimestamp && strings.EqualFold(s, "now") {
+		return time.Now(), nil
+	}
+	if s, ok := f.Default.(string); ok && f.Type == config.FieldUUID && strings.EqualFold(s, "random") {
+		return uuid.New().String(), nil
+	}
+	return coerceValue(f, f.Default)
+}
+
+// coerceValue converts a decoded body value into the Go value of the
+// field type. Decimals are kept as their canonical string.
+func coerceValue(f config.Field, v any) (any, error) {
+	if v == nil {
+		if !f.Nullable {
+			return nil, fmt.Errorf("must not be null")
+		}
+		return nil, nil
+	}
+
+	switch f.Type {
+	case config.FieldInt:
+		switch t := v.(type) {
+		case json.Number:
+			n, err := strconv.ParseInt(t.String(), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("must be an integer")
+			}
+			return n, nil
+		case float64:
+			if t != math.Trunc(t) {
+				return nil, fmt.Errorf("must be an integer")
+			}
+			return int64(t), nil
+		case int:
+			return int64(t), nil
+		case int64:
+			return t, nil
+		case string:
+			n, err := strconv.ParseInt(strings.TrimSpace(t), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("must be an integer")
+			}
+			return n, nil
+		}
+		return nil, fmt.Errorf("must be an integer")
+
+	case config.FieldFloat:
+		switch t := v.(type) {
+		case json.Number:
+			n, err := t.Float64()
+			if err != nil {
+				return nil, fmt.Errorf("must be a number")
+			}
+			return n, nil
+		case float64:
+			return t, nil
+		case int:
+			return float64(t), nil
+		case int64:
+			return float64(t), nil
+		case string:
+			n, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+			if err != nil {
+				return nil, fmt.Errorf("must be a number")
+			}
+			return n, nil
+		}
+		return nil, fmt.Errorf("must be a number")
+
+	case config.FieldDecimal:
+		var s string
+		switch t := v.(type) {
+		case json.Number:
+			s = t.String()
+		case string:
+			s = strings.TrimSpace(t)
+		case float64:
+			s = strconv.FormatFloat(t, 'f', -1, 64)
+		case int:
+			s = strconv.Itoa(t)
+		case int64:
+			s = strconv.FormatInt(t, 10)
+		default:
+			return nil, fmt.Errorf("must be a decimal number")
+		}
+		if !decimalRe.MatchString(s) {
+			return nil, fmt.Errorf("must be a decimal number")
+		}
+		return s, nil
+
+	case config.FieldBool:
+		switch t := v.(type) {
+		case bool:
+			return t, nil
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(t))
+			if err != nil {
+				return nil, fmt.Errorf("must be a boolean")
+			}
+			return b, nil
+		case json.Number:
+			switch t.String() {
+			case "0":
+				return false, nil
+			case "1":
+				return true, nil
+			}
+		}
+		return nil, fmt.Errorf("must be a boolean")
+
+	case config.FieldTimestamp:
+		switch t := v.(type) {
+		case time.Time:
+			return t, nil
+		case string:
+			ts, err := db.ParseTimestamp(t)
+			if err != nil {
+				return nil, fmt.Errorf("must be an RFC3339 timestamp")
+			}
+			return ts, nil
+		case json.Number:
+			n, err := t.Int64()
+			if err != nil {
+				return nil, fmt.Errorf("must be an RFC3339 timestamp")
+			}
+			return time.Unix(n, 0).UTC(), nil
+		}
+		return nil, fmt.Errorf("must be an RFC3339 timestamp")
+
+	case config.FieldUUID:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be a UUID")
+		}
+		id, err := uuid.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("must be a UUID")
+		}
+		return id.String(), nil
+
+	case config.FieldJSON:
+		return v, nil
+	}
+
+	// string
+	switch t := v.(type) {
+	case string:
+		return t, nil
+	case json.Number:
+		return t.String(), nil
+	case bool:
+		return strconv.FormatBool(t), nil
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), nil
+	case int, int64:
+		return fmt.Sprint(t), nil
+	}
+	return nil, fmt.Errorf("must be a string")
+}
+
+// -------------------------------------
+// SQL
+// -------------------------------------
+
+// sqlValue converts a coerced value into a driver argument
+func sqlValue(f config.Field, v any) any {
+	if v == nil {
+		return nil
+	}
+	if f.Type == config.FieldJSON {
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	return v
+}
+
+// columnScanner scans a single column and renders it with the JSON type
+// of its field, regardless of how the driver returns it.
+type columnScanner struct {
+	field config.Field
+	value any
+}
+
+func (s *columnScanner) Scan(src any) error {
+	if src == nil {
+		s.value = nil
+		return nil
+	}
+
+	if b, ok := src.([]byte); ok {
+		src = string(b)
+	}
+
+	switch s.field.Type {
+	case config.FieldInt:
+		switch t := src.(type) {
+		case int64:
+			s.value = t
+		case string:
+			n, err := strconv.ParseInt(t, 10, 64)
+			if err != nil {
+				return fmt.Errorf("field %s: %w", s.field.Name, err)
+			}
+			s.value = n
+		case float64:
+			s.value = int64(t)
+		default:
+			return fmt.Errorf("field %s: cannot scan %T as int", s.field.Name, src)
+		}
+
+	case config.FieldFloat:
+		switch t := src.(type) {
+		case float64:
+			s.value = t
+		case float32:
+			s.value = float64(t)
+		case int64:
+			s.value = float64(t)
+		case string:
+			n, err := strconv.ParseFloat(t, 64)
+			if err != nil {
+				return fmt.Errorf("field %s: %w", s.field.Name, err)
+			}
+			s.value = n
+		default:
+			return fmt.Errorf("field %s: cannot scan %T as float", s.field.Name, src)
+		}
+
+	case config.FieldDecimal:
+		// json.Number keeps precision and is rendered as a JSON number
+		s.value = json.Number(fmt.Sprint(src))
+
+	case config.FieldBool:
+		switch t := src.(type) {
+		case bool:
+			s.value = t
+		case int64:
+			s.value = t != 0
+		case string:
+			b, err := strconv.ParseBool(t)
+			if err != nil {
+				return fmt.Errorf("field %s: %w", s.field.Name, err)
+			}
+			s.value = b
+		default:
+			return fmt.Errorf("field %s: cannot scan %T as bool", s.field.Name, src)
+		}
+
+	case config.FieldTimestamp:
+		switch t := src.(type) {
+		case time.Time:
+			s.value = t
+		case string:
+			ts, err := db.ParseTimestamp(t)
+			if err != nil {
+				return fmt.Errorf("field %s: %w", s.field.Name, err)
+			}
+			s.value = ts
+		default:
+			return fmt.Errorf("field %s: cannot scan %T as timestamp", s.field.Name, src)
+		}
+
+	case config.FieldJSON:
+		str := fmt.Sprint(src)
+		if json.Valid([]byte(str)) {
+			s.value = json.RawMessage(str)
+		} else {
+			s.value = str
+		}
+
+	default:
+		switch t := src.(type) {
+		case string:
+			s.value = t
+		case time.Time:
+			s.value = t.Format(time.RFC3339Nano)
+		default:
+			s.value = fmt.Sprint(t)
+		}
+	}
+
+	return nil
+}
+
+// newRowScanner prepares scan targets for fields and returns a function
+// building the output item after rows.Scan.
+func newRowScanner(fields []config.Field) ([]any, func() map[string]any) {
+	scanners := make([]columnScanner, len(fields))
+	targets := make([]any, len(fields))
+	for i, f := range fields {
+		scanners[i].field = f
+		targets[i] = &scanners[i]
+	}
+
+	return targets, func() map[string]any {
+		item := make(map[string]any, len(fields))
+		for i, f := range fields {
+			item[f.Name] = scanners[i].value
+		}
+		return item
+	}
+}
+
+// -------------------------------------
+// MONGO
+// -------------------------------------
+
+// mongoValue converts a coerced value into its BSON representation
+func mongoValue(f config.Field, v any) any {
+	if v == nil {
+		return nil
+	}
+	if f.Type == config.FieldDecimal {
+		if d, err := primitive.ParseDecimal128(v.(string)); err == nil {
+			return d
+		}
+	}
+	if f.Type == config.FieldJSON {
+		return normalizeJSONNumbers(v)
+	}
+	return v
+}
+
+// normalizeJSONNumbers turns json.Number into int64/float64 so the mongo
+// driver stores them as numbers instead of strings.
+func normalizeJSONNumbers(v any) any {
+	switch t := v.(type) {
+	case json.Number:
+		if n, err := t.Int64(); err == nil {
+			return n
+		}
+		f, _ := t.Float64()
+		return f
+	case map[string]any:
+		for k, e := range t {
+			t[k] = normalizeJSONNumbers(e)
+		}
+	case []any:
+		for i, e := range t {
+			t[i] = normalizeJSONNumbers(e)
+		}
+	}
+	return v
+}
+
+// mongoOutput renders a stored document with the JSON types of fields
+func mongoOutput(fields []config.Field, doc bson.M) map[string]any {
+	item := make(map[string]any, len(fields))
+	for _, f := range fields {
+		v, ok := doc[f.Name]
+		if !ok {
+			item[f.Name] = nil
+			continue
+		}
+
+		switch t := v.(type) {
+		case primitive.DateTime:
+			item[f.Name] = t.Time().UTC()
+		case primitive.Decimal128:
+			item[f.Name] = json.Number(t.String())
+		case int32:
+			item[f.Name] = int64(t)
+		default:
+			item[f.Name] = v
+		}
+	}
+	return item
+}
@@ -0,0 +1,298 @@
+package modules
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/cunkz/goyummy/bin/config"
+	"github.com/cunkz/goyummy/bin/helpers/db"
+)
+
+// Filter operators accepted as field[op]=value, a bare field=value is eq
+const (
+	opEq      = "eq"
+	opNe      = "ne"
+	opGt      = "gt"
+	opGte     = "gte"
+	opLt      = "lt"
+	opLte     = "lte"
+	opIn      = "in"
+	opNin     = "nin"
+	opLike    = "like"
+	opIsNull  = "isnull"
+	opBetween = "between"
+)
+
+// Query parameters that are never treated as filters
+var reservedParams = map[string]bool{
+	"page":   true,
+	"limit":  true,
+	"sort":   true,
+	"cursor": true,
+	"fields": true,
+}
+
+var filterKeyRe = regexp.MustCompile(`^([A-Za-z0-9_]+)(?:\[([a-z]+)\])?$`)
+
+type filterCond struct {
+	Field  config.Field
+	Op     string
+	Values []any
+}
+
+// parseFilters turns the query string into conditions on declared fields.
+// Values are coerced with the field type so they bind as proper params.
+func parseFilters(c *fiber.Ctx, fields []config.Field) ([]filterCond, error) {
+	byName := map[string]config.Field{}
+	for _, f := range fields {
+		byName[f.Name] = f
+	}
+
+	var conds []filterCond
+	var parseErr error
+
+	c.Context().QueryArgs().VisitAll(func(k, v []byte) {
+		key := string(k)
+		if parseErr != nil || reservedParams[key] {
+			return
+		}
+
+		match := filterKeyRe.FindStringSubmatch(key)
+		if match == nil {
+			parseErr = fmt.Errorf("invalid filter %s", key)
+			return
+		}
+		f, ok := byName[match[1]]
+		if !ok {
+			parseErr = fmt.Errorf("cannot filter by unknown field %s", match[1])
+			return
+		}
+		op := match[2]
+		if op == "" {
+			op = opEq
+		}
+
+		cond, err := newFilterCond(f, op, string(v))
+		if err != nil {
+			parseErr = fmt.Errorf("filter %s: %w", key, err)
+			return
+		}
+		conds = append(conds, cond)
+	})
+
+	return conds, parseErr
+}
+
+func newFilterCond(f config.Field, op, raw string) (filterCond, error) {
+	cond := filterCond{Field: f, Op: op}
+
+	// compare against the field type, null is only reachable through isnull
+	vf := f
+	vf.Nullable = false
+
+	coerce := func(s string) error {
+		v, err := coerceValue(vf, s)
+		if err != nil {
+			return err
+		}
+		cond.Values = append(cond.Values, v)
+		return nil
+	}
+
+	if f.Type == config.FieldJSON && op != opIsNull {
+		return cond, fmt.Errorf("json fields only support isnull")
+	}
+
+	switch op {
+	case opEq, opNe, opGt, opGte, opLt, opLte:
+		return cond, coerce(raw)
+
+	case opIn, opNin:
+		for _, s := range strings.Split(raw, ",") {
+			if err := coerce(s); err != nil {
+				return cond, err
+			}
+		}
+		return cond, nil
+
+	case opBetween:
+		parts := strings.Split(raw, ",")
+		if len(parts) != 2 {
+			return cond, fmt.Errorf("between needs two comma separated values")
+		}
+		for _, s := range parts {
+			if err := coerce(s); err != nil {
+				return cond, err
+			}
+		}
+		return cond, nil
+
+	case opLike:
+		if f.Type != config.FieldString {
+			return cond, fmt.Errorf("like is only supported on string fields")
+		}
+		cond.Values = append(cond.Values, raw)
+		return cond, nil
+
+	case opIsNull:
+		v, err := coerceValue(config.Field{Type: config.FieldBool}, raw)
+		if err != nil {
+			return cond, err
+		}
+		cond.Values = append(cond.Values, v)
+		return cond, nil
+	}
+
+	return cond, fmt.Errorf("unsupported operator %s", op)
+}
+
+// -------------------------------------
+// SQL
+// -------------------------------------
+
+// sqlWhere collects AND-ed predicates and their bind arguments
+type sqlWhere struct {
+	dialect db.Dialect
+	parts   []string
+	args    []any
+}
+
+func newSQLWhere(d db.Dialect) *sqlWhere {
+	return &sqlWhere{dialect: d}
+}
+
+// arg binds v and returns its placeholder
+func (w *sqlWhere) arg(v any) string {
+	w.args = append(w.args, v)
+	return w.dialect.Placeholder(len(w.args))
+}
+
+func (w *sqlWhere) add(expr string) {
+	w.parts = append(w.parts, expr)
+}
+
+func (w *sqlWhere) clause() string {
+	if len(w.parts) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(w.parts, " AND ")
+}
+
+var sqlOperators = map[string]string{
+	opEq:  "=",
+	opNe:  "<>",
+	opGt:  ">",
+	opGte: ">=",
+	opLt:  "<",
+	opLte: "<=",
+}
+
+func (w *sqlWhere) addFilters(conds []filterCond) {
+	for _, cond := range conds {
+		col := w.dialect.Quote(cond.Field.Name)
+		f := cond.Field
+
+		switch cond.Op {
+		case opEq, opNe, opGt, opGte, opLt, opLte:
+			w.add(col + " " + sqlOperators[cond.Op] + " " + w.arg(sqlValue(f, cond.Values[0])))
+
+		case opIn, opNin:
+			phs := make([]string, len(cond.Values))
+			for i, v := range cond.Values {
+				phs[i] = w.arg(sqlValue(f, v))
+			}
+			not := ""
+			if cond.Op == opNin {
+				not = "NOT "
+			}
+			w.add(col + " " + not + "IN (" + strings.Join(phs, ", ") + ")")
+
+		case opBetween:
+			w.add(col + " BETWEEN " + w.arg(sqlValue(f, cond.Values[0])) + " AND " + w.arg(sqlValue(f, cond.Values[1])))
+
+		case opLike:
+			// case-insensitive contains, same on every engine
+			pattern := "%" + escapeLike(cond.Values[0].(string)) + "%"
+			w.add("LOWER(" + col + ") LIKE LOWER(" + w.arg(pattern) + ")")
+
+		case opIsNull:
+			if cond.Values[0].(bool) {
+				w.add(col + " IS NULL")
+			} else {
+				w.add(col + " IS NOT NULL")
+			}
+		}
+	}
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// -------------------------------------
+// MONGO
+// -------------------------------------
+
+var mongoOperators = map[string]string{
+	opEq:  "$eq",
+	opNe:  "$ne",
+	opGt:  "$gt",
+	opGte: "$gte",
+	opLt:  "$lt",
+	opLte: "$lte",
+	opIn:  "$in",
+	opNin: "$nin",
+}
+
+// mongoFilter compiles conditions into a filter, each one its own $and
+// entry so several conditions on one field do not overwrite each other.
+// extra predicates are AND-ed as they are.
+func mongoFilter(conds []filterCond, extra ...bson.M) bson.M {
+	and := append([]bson.M{}, extra...)
+	for _, cond := range conds {
+		f := cond.Field
+		name := f.Name
+
+		switch cond.Op {
+		case opEq, opNe, opGt, opGte, opLt, opLte:
+			and = append(and, bson.M{name: bson.M{mongoOperators[cond.Op]: mongoValue(f, cond.Values[0])}})
+
+		case opIn, opNin:
+			vals := make([]any, len(cond.Values))
+			for i, v := range cond.Values {
+				vals[i] = mongoValue(f, v)
+			}
+			and = append(and, bson.M{name: bson.M{mongoOperators[cond.Op]: vals}})
+
+		case opBetween:
+			and = append(and, bson.M{name: bson.M{
+				"$gte": mongoValue(f, cond.Values[0]),
+				"$lte": mongoValue(f, cond.Values[1]),
+			}})
+
+		case opLike:
+			and = append(and, bson.M{name: bson.M{
+				"$regex":   regexp.QuoteMeta(cond.Values[0].(string)),
+				"$options": "i",
+			}})
+
+		case opIsNull:
+			if cond.Values[0].(bool) {
+				and = append(and, bson.M{name: nil})
+			} else {
+				and = append(and, bson.M{name: bson.M{"$ne": nil}})
+			}
+		}
+	}
+
+	if len(and) == 0 {
+		return bson.M{}
+	}
+	return bson.M{"$and": and}
+}
@@ -0,0 +1,114 @@
+package modules
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+
+	"github.com/cunkz/goyummy/bin/config"
+	"github.com/cunkz/goyummy/bin/helpers/db"
+)
+
+var filterFields = []config.Field{
+	{Name: "name", Type: config.FieldString},
+	{Name: "stock", Type: config.FieldInt},
+	{Name: "price", Type: config.FieldDecimal},
+	{Name: "active", Type: config.FieldBool},
+	{Name: "meta", Type: config.FieldJSON, Nullable: true},
+}
+
+// withQuery runs f on a request of query
+func withQuery(t *testing.T, query string, f func(c *fiber.Ctx)) {
+	t.Helper()
+	app := fiber.New()
+	fctx := &fasthttp.RequestCtx{}
+	fctx.Request.SetRequestURI("/items?" + query)
+	c := app.AcquireCtx(fctx)
+	defer app.ReleaseCtx(c)
+	f(c)
+}
+
+func TestParseFilters(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []filterCond
+		err   string
+	}{
+		{query: "", want: nil},
+		{query: "page=2&limit=10&sort=-name&fields=name", want: nil},
+		{query: "name=pen", want: []filterCond{{filterFields[0], opEq, []any{"pen"}}}},
+		{query: "stock[gte]=5", want: []filterCond{{filterFields[1], opGte, []any{int64(5)}}}},
+		{query: "stock[in]=1,2,3", want: []filterCond{{filterFields[1], opIn, []any{int64(1), int64(2), int64(3)}}}},
+		{query: "price[between]=1.5,10", want: []filterCond{{filterFields[2], opBetween, []any{"1.5", "10"}}}},
+		{query: "name[like]=50%_off", want: []filterCond{{filterFields[0], opLike, []any{"50%_off"}}}},
+		{query: "meta[isnull]=true", want: []filterCond{{filterFields[4], opIsNull, []any{true}}}},
+		{
+			query: "stock[gt]=1&stock[lt]=9",
+			want:  []filterCond{{filterFields[1], opGt, []any{int64(1)}}, {filterFields[1], opLt, []any{int64(9)}}},
+		},
+		{query: "color=red", err: "cannot filter by unknown field color"},
+		{query: "name[foo]=x", err: "filter name[foo]: unsupported operator foo"},
+		{query: "name[EQ]=x", err: "invalid filter name[EQ]"},
+		{query: "stock=many", err: "filter stock: must be an integer"},
+		{query: "stock[in]=1,x", err: "filter stock[in]: must be an integer"},
+		{query: "price[between]=1", err: "filter price[between]: between needs two comma separated values"},
+		{query: "price=0x10", err: "filter price: must be a decimal number"},
+		{query: "stock[like]=1", err: "filter stock[like]: like is only supported on string fields"},
+		{query: "meta=1", err: "filter meta: json fields only support isnull"},
+		{query: "active[isnull]=maybe", err: "filter active[isnull]: must be a boolean"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			withQuery(t, tt.query, func(c *fiber.Ctx) {
+				got, err := parseFilters(c, filterFields)
+				if tt.err != "" {
+					if err == nil || err.Error() != tt.err {
+						t.Fatalf("error = %v, want %q", err, tt.err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("conditions = %+v, want %+v", got, tt.want)
+				}
+			})
+		})
+	}
+}
+
+func TestSQLWhereFilters(t *testing.T) {
+	tests := []struct {
+		engine string
+		query  string
+		clause string
+		args   []any
+	}{
+		{"postgres", "", "", nil},
+		{"postgres", "name=pen&stock[gt]=2", ` WHERE "name" = $1 AND "stock" > $2`, []any{"pen", int64(2)}},
+		{"mysql", "stock[nin]=1,2", " WHERE `stock` NOT IN (?, ?)", []any{int64(1), int64(2)}},
+		{"postgres", "name[like]=50%_off", ` WHERE LOWER("name") LIKE LOWER($1)`, []any{`%50\%\_off%`}},
+		{"postgres", "meta[isnull]=false", ` WHERE "meta" IS NOT NULL`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.engine+" "+tt.query, func(t *testing.T) {
+			withQuery(t, tt.query, func(c *fiber.Ctx) {
+				conds, err := parseFilters(c, filterFields)
+				if err != nil {
+					t.Fatal(err)
+				}
+				w := newSQLWhere(db.GetDialect(tt.engine))
+				w.addFilters(conds)
+				if got := w.clause(); got != tt.clause {
+					t.Errorf("clause = %q, want %q", got, tt.clause)
+				}
+				if !reflect.DeepEqual(w.args, tt.args) {
+					t.Errorf("args = %#v, want %#v", w.args, tt.args)
+				}
+			})
+		})
+	}
+}
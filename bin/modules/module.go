@@ -3,7 +3,6 @@ package modules
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 
 	// "database/sql"
 	"fmt"
@@ -15,6 +14,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"github.com/cunkz/goyummy/bin/config"
 	"github.com/cunkz/goyummy/bin/helpers/auth"
@@ -22,87 +22,199 @@ import (
 	"github.com/cunkz/goyummy/bin/helpers/utils"
 )
 
-func RegisterModules(app *fiber.App, cfg *config.AppConfig) {
+// RegisterModules adds the routes of every module and token endpoint, the
+// handlers read and write the databases of reg
+func RegisterModules(app *fiber.App, cfg *config.AppConfig, reg *db.Registry) error {
 	// Initalize Auth
-	authMap, _ := auth.BuildAuthMap(cfg)
+	authMap, issuers, err := auth.BuildAuthMap(cfg, reg)
+	if err != nil {
+		return err
+	}
 
 	for _, m := range cfg.Modules {
-		mSlug := utils.ToSlug(m.Name)
-		baseRoute := fmt.Sprintf("/api/%s/v1", mSlug)
-		authMiddleware := authMap[m.Auth]
 		dbEngine := config.GetDBEngineByName(cfg, m.Database)
-		if dbEngine == "mongo" {
-			registerModuleMongo(app, authMiddleware, baseRoute, m)
+
+		var handlers map[string]fiber.Handler
+		if dbEngine == config.EngineMongo {
+			handlers = mongoHandlers(cfg, reg, m)
 		} else {
-			registerModule(app, authMiddleware, baseRoute, m)
+			handlers = sqlHandlers(cfg, reg, m, dbEngine)
+		}
+		if handlers == nil {
+			continue
+		}
+
+		for _, r := range moduleRoutes(cfg, m) {
+			if err := registerRoute(app, r, handlers[r.Operation], authMap); err != nil {
+				return err
+			}
 		}
 	}
+
+	return registerTokenRoutes(app, cfg, reg, issuers)
 }
 
-func registerModule(app *fiber.App, authMiddleware fiber.Handler, baseRoute string, m config.Module) {
-	db := db.PostgresDBs[m.Database] // for now: postgres engine
+// registerRoute is the single place routes are added, so every engine
+// gets the auth of its operation the same way.
+func registerRoute(app *fiber.App, r Route, handler fiber.Handler, authMap map[string]fiber.Handler) error {
+	if handler == nil {
+		return nil
+	}
 
-	m.Fields = append(m.Fields, "id")
-	m.Fields = append(m.Fields, "created_at")
-	m.Fields = append(m.Fields, "updated_at")
-	fields := strings.Join(m.Fields, ",")
-	placeholders := make([]string, len(m.Fields))
-	for i := range m.Fields {
-		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	chain := []fiber.Handler{}
+	if r.Auth != "" {
+		authMiddleware, ok := authMap[r.Auth]
+		if !ok {
+			// never fall back to a public route
+			return fmt.Errorf("module %s: auth %q is not configured", r.Module, r.Auth)
+		}
+		chain = append(chain, authMiddleware)
+	}
+	if r.Require != nil {
+		chain = append(chain, auth.Require(*r.Require))
 	}
-	placeholdersStr := strings.Join(placeholders, ",")
+	chain = append(chain, handler)
 
+	app.Add(r.Method, r.Path, chain...)
+	log.Info().Msgf("Add Route %s %s (auth: %s)", r.Method, r.Path, authName(r.Auth))
+	return nil
+}
+
+func authName(name string) string {
+	if name == "" {
+		return "public"
+	}
+	return name
+}
+
+// sqlHandlers builds the operation handlers of a Postgres or MySQL module
+func sqlHandlers(cfg *config.AppConfig, reg *db.Registry, m config.Module, dbEngine string) map[string]fiber.Handler {
+	src := newSQLSource(cfg, reg, m, dbEngine)
+	dialect := src.dialect
+	// with a database per tenant, the one of the module is only a name
+	if dialect == nil || (src.fixedDatabase() && reg.SQL(dbEngine, m.Database) == nil) {
+		log.Error().Msgf("Database %s (%s) is not available for Module: %s", m.Database, dbEngine, m.Name)
+		return nil
+	}
+
+	table := dialect.Quote(m.Table)
+	idCol := dialect.Quote("id")
+
+	fields := allFields(m)
+	vd, err := newValidator(m.Fields)
+	if err != nil {
+		log.Error().Err(err).Msgf("Invalid validation rules for Module: %s", m.Name)
+		return nil
+	}
+	selectList := func(fs []config.Field) string {
+		return strings.Join(db.QuoteAll(dialect, fieldNames(fs)), ",")
+	}
+
+	handlers := map[string]fiber.Handler{}
 	for _, op := range m.Operations {
-		switch strings.ToLower(op) {
-		case "create":
+		op = strings.ToLower(op)
+		switch op {
+		case config.OpCreate:
 			// ----------------------------
 			// CREATE (INSERT)
 			// ----------------------------
-			createHandler := func(c *fiber.Ctx) error {
-				body := map[string]string{}
-				if err := c.BodyParser(&body); err != nil {
-					return err
+			createHandler := func(c *fiber.Ctx, q sqlQuerier, scopes rowScopes) error {
+				body, err := parseBody(c)
+				if err != nil {
+					return utils.ResponseError(c, 400, "Invalid request body")
 				}
+				scopes.stamp(body)
 
+				values, errs := vd.values(body, true)
+				if len(errs) > 0 {
+					return responseInvalid(c, errs)
+				}
+
+				id := uuid.New().String()
+				now := time.Now()
+
+				cols := []string{}
 				args := []any{}
 				for _, f := range m.Fields {
-					switch f {
-					case "id":
-						args = append(args, uuid.New().String())
-					case "created_at", "updated_at":
-						args = append(args, time.Now())
-					default:
-						args = append(args, body[f])
+					if v, ok := values[f.Name]; ok {
+						cols = append(cols, dialect.Quote(f.Name))
+						args = append(args, sqlValue(f, v))
 					}
 				}
+				for col, v := range scopes.insertColumns() {
+					cols = append(cols, dialect.Quote(col))
+					args = append(args, v)
+				}
+				cols = append(cols, idCol, dialect.Quote("created_at"), dialect.Quote("updated_at"))
+				args = append(args, id, now, now)
 
-				query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING id",
-					m.Table, fields, placeholdersStr)
+				query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+					table, strings.Join(cols, ","), strings.Join(db.Placeholders(dialect, 1, len(cols)), ","))
 
-				var id string
-				err := db.QueryRow(query, args...).Scan(&id)
-				if err != nil {
-					return err
+				if dialect.SupportsReturning() {
+					err := q.QueryRow(query+" RETURNING "+idCol, args...).Scan(&id)
+					if err != nil {
+						return err
+					}
+				} else {
+					// id is generated here, so it is already known
+					if _, err := q.Exec(query, args...); err != nil {
+						return err
+					}
 				}
 
 				return utils.ResponseSuccess(c, fiber.Map{"id": id}, "Data has been created")
 			}
-			if authMiddleware != nil {
-				app.Post(baseRoute, authMiddleware, createHandler)
-			} else {
-				app.Post(baseRoute, createHandler)
-			}
-			log.Info().Msgf("Add Route POST %s", baseRoute)
-		case "read_list":
+			handlers[op] = src.handler(createHandler)
+		case config.OpReadList:
 			// ----------------------------
 			// GET ALL
 			// ----------------------------
-			getHandler := func(c *fiber.Ctx) error {
-				// Make SELECT list: "id, name, description ..."
-				cols := append([]string{"id"}, m.Fields...)
-				query := "SELECT " + strings.Join(cols, ",") + " FROM " + m.Table
+			getHandler := func(c *fiber.Ctx, q sqlQuerier, scopes rowScopes) error {
+				params, err := parseListParams(c, fields, m.Pagination)
+				if err != nil {
+					return utils.ResponseError(c, 400, err.Error())
+				}
+
+				filters, err := parseFilters(c, fields)
+				if err != nil {
+					return utils.ResponseError(c, 400, err.Error())
+				}
+
+				selected, err := parseProjection(c, fields)
+				if err != nil {
+					return utils.ResponseError(c, 400, err.Error())
+				}
+				// the cursor is built from the sort key, so it is always read
+				queryFields := selected
+				if params.Cursor {
+					queryFields = withField(selected, params.SortField)
+				}
+
+				where := newSQLWhere(dialect)
+				where.addFilters(filters)
+				scopes.sqlWhere(where)
 
-				rows, err := db.Query(query)
+				var total int64
+				limit, offset := params.Limit, params.offset()
+				if params.Cursor {
+					// keyset: no count, fetch one extra row to know if there is a next page
+					if params.After != nil {
+						where.addKeyset(params.SortField, params.After)
+					}
+					limit, offset = limit+1, 0
+				} else {
+					if err := q.QueryRow("SELECT COUNT(*) FROM "+table+where.clause(), where.args...).Scan(&total); err != nil {
+						return err
+					}
+				}
+
+				// Make SELECT list: "name, description, ..., id, created_at, updated_at"
+				query := "SELECT " + selectList(queryFields) + " FROM " + table + where.clause() +
+					sqlOrderBy(dialect, params.Sort) +
+					dialect.LimitOffset(limit, offset)
+
+				rows, err := q.Query(query, where.args...)
 				if err != nil {
 					return err
 				}
@@ -111,219 +223,300 @@ func registerModule(app *fiber.App, authMiddleware fiber.Handler, baseRoute stri
 				list := []map[string]any{}
 
 				for rows.Next() {
-					// prepare scan targets
-					scanTargets := make([]any, len(cols))
-
-					// id (string)
-					var id sql.NullString
-					scanTargets[0] = &id
-
-					// other fields (string)
-					nullFields := make([]sql.NullString, len(m.Fields))
-					for i := range nullFields {
-						scanTargets[i+1] = &nullFields[i]
-					}
+					// prepare scan targets typed by field definition
+					scanTargets, item := newRowScanner(queryFields)
 
 					// execute scan
 					if err := rows.Scan(scanTargets...); err != nil {
 						return err
 					}
 
-					// build output
-					item := map[string]any{
-						"id": id.String,
-					}
+					list = append(list, item())
+				}
+				if err := rows.Err(); err != nil {
+					return err
+				}
 
-					for i, f := range m.Fields {
-						if nullFields[i].Valid {
-							item[f] = nullFields[i].String
-						} else {
-							item[f] = nil
-						}
+				if params.Cursor {
+					list, meta, err := cursorPage(cursorSecret(m.Pagination), params, list, selected)
+					if err != nil {
+						return err
 					}
-
-					list = append(list, item)
+					return utils.ResponseSuccessMeta(c, list, meta, "Successfully read data")
 				}
 
-				// return c.JSON(list)
-
-				return utils.ResponseSuccess(c, list, "Successfully read data")
+				return utils.ResponseSuccessMeta(c, list, newPageMeta(params, total), "Successfully read data")
 			}
-			if authMiddleware != nil {
-				app.Get(baseRoute, authMiddleware, getHandler)
-			} else {
-				app.Get(baseRoute, getHandler)
-			}
-			log.Info().Msgf("Add Route GET %s", baseRoute)
-		case "read_single":
+			handlers[op] = src.handler(getHandler)
+		case config.OpReadSingle:
 			// ----------------------------
 			// GET by ID
 			// ----------------------------
-			getHandler := func(c *fiber.Ctx) error {
+			getHandler := func(c *fiber.Ctx, q sqlQuerier, scopes rowScopes) error {
 				id := c.Params("id")
 
-				query := "SELECT " + fields + " FROM " + m.Table + " WHERE id=$1"
+				selected, err := parseProjection(c, fields)
+				if err != nil {
+					return utils.ResponseError(c, 400, err.Error())
+				}
 
-				row := db.QueryRow(query, id)
+				where := newSQLWhere(dialect)
+				where.add(idCol + "=" + where.arg(id))
+				scopes.sqlWhere(where)
+				query := "SELECT " + selectList(selected) + " FROM " + table + where.clause()
 
-				result := map[string]any{"id": id}
-				values := make([]any, len(m.Fields))
-				for i := range m.Fields {
-					var v string
-					values[i] = &v
-				}
+				row := q.QueryRow(query, where.args...)
 
-				err := row.Scan(values...)
-				if err != nil {
+				scanTargets, item := newRowScanner(selected)
+				err = row.Scan(scanTargets...)
+				if err == sql.ErrNoRows {
 					return utils.ResponseError(c, 404, "Data not found")
 				}
-
-				for i, f := range m.Fields {
-					result[f] = *(values[i].(*string))
+				if err != nil {
+					return err
 				}
+				result := item()
 
 				return utils.ResponseSuccess(c, result, "Successfully read data")
 			}
-			if authMiddleware != nil {
-				app.Get(baseRoute+"/:id", authMiddleware, getHandler)
-			} else {
-				app.Get(baseRoute+"/:id", getHandler)
-			}
-			log.Info().Msgf("Add Route GET %s", baseRoute+"/:id")
-		case "update":
+			handlers[op] = src.handler(getHandler)
+		case config.OpUpdate:
 			// ----------------------------
 			// UPDATE
 			// ----------------------------
-			updateHandler := func(c *fiber.Ctx) error {
+			updateHandler := func(c *fiber.Ctx, q sqlQuerier, scopes rowScopes) error {
 				id := c.Params("id")
-				body := map[string]string{}
-				_ = c.BodyParser(&body)
+				body, err := parseBody(c)
+				if err != nil {
+					return utils.ResponseError(c, 400, "Invalid request body")
+				}
+				scopes.protect(body)
+
+				values, errs := vd.values(body, false)
+				if len(errs) > 0 {
+					return responseInvalid(c, errs)
+				}
 
+				// SET values and WHERE predicates share the bind arguments
+				where := newSQLWhere(dialect)
 				sets := []string{}
-				args := []any{}
-				argNum := 1
 
 				for _, f := range m.Fields {
-					if v, ok := body[f]; ok {
-						sets = append(sets, fmt.Sprintf("%s=$%d", f, argNum))
-						args = append(args, v)
-						argNum++
+					if v, ok := values[f.Name]; ok {
+						sets = append(sets, dialect.Quote(f.Name)+"="+where.arg(sqlValue(f, v)))
 					}
 				}
 
-				// Add function refresh updated_at
-				sets = append(sets, fmt.Sprintf("%s=$%d", "updated_at", argNum))
-				args = append(args, time.Now())
-				argNum++
-
 				if len(sets) == 0 {
 					return utils.ResponseError(c, 400, "No fields provided")
 				}
 
-				args = append(args, id)
+				// Add function refresh updated_at
+				sets = append(sets, dialect.Quote("updated_at")+"="+where.arg(time.Now()))
 
-				query := fmt.Sprintf("UPDATE %s SET %s WHERE id=$%d",
-					m.Table, strings.Join(sets, ", "), argNum)
+				where.add(idCol + "=" + where.arg(id))
+				scopes.sqlWhere(where)
 
-				_, err := db.Exec(query, args...)
-				if err != nil {
+				query := fmt.Sprintf("UPDATE %s SET %s%s", table, strings.Join(sets, ", "), where.clause())
+
+				if _, err := q.Exec(query, where.args...); err != nil {
 					return err
 				}
 
 				return utils.ResponseSuccess(c, fiber.Map{"updated": true}, "Successfully update data")
 			}
-			if authMiddleware != nil {
-				app.Patch(baseRoute+"/:id", authMiddleware, updateHandler)
-			} else {
-				app.Patch(baseRoute+"/:id", updateHandler)
-			}
-			log.Info().Msgf("Add Route PATCH %s", baseRoute+"/:id")
-		case "delete":
+			handlers[op] = src.handler(updateHandler)
+		case config.OpDelete:
 			// ----------------------------
 			// DELETE
 			// ----------------------------
-			deleteHandler := func(c *fiber.Ctx) error {
+			deleteHandler := func(c *fiber.Ctx, q sqlQuerier, scopes rowScopes) error {
 				id := c.Params("id")
 
-				_, err := db.Exec("DELETE FROM "+m.Table+" WHERE id=$1", id)
+				where := newSQLWhere(dialect)
+				where.add(idCol + "=" + where.arg(id))
+				scopes.sqlWhere(where)
+
+				_, err := q.Exec("DELETE FROM "+table+where.clause(), where.args...)
 				if err != nil {
 					return err
 				}
 
 				return utils.ResponseSuccess(c, fiber.Map{"deleted": true}, "Successfully delete data")
 			}
-			if authMiddleware != nil {
-				app.Delete(baseRoute+"/:id", authMiddleware, deleteHandler)
-			} else {
-				app.Delete(baseRoute+"/:id", deleteHandler)
-			}
-			log.Info().Msgf("Add Route DELETE %s", baseRoute+"/:id")
+			handlers[op] = src.handler(deleteHandler)
 		default:
 			log.Info().Msgf("Invalid Operation for Module: %s", m.Name)
 		}
 	}
+	return handlers
 }
 
-func registerModuleMongo(app *fiber.App, authMiddleware fiber.Handler, baseRoute string, m config.Module) {
-	mongoDB := db.MongoDBs[m.Database]
-	col := mongoDB.Collection(m.Table)
+// mongoHandlers builds the operation handlers of a MongoDB module
+func mongoHandlers(cfg *config.AppConfig, reg *db.Registry, m config.Module) map[string]fiber.Handler {
+	src := &mongoSource{scoper: newScoper(cfg, m), reg: reg, database: m.Database, table: m.Table}
+	if src.fixedDatabase() && reg.MongoDB(m.Database) == nil {
+		log.Error().Msgf("Database %s (mongo) is not available for Module: %s", m.Database, m.Name)
+		return nil
+	}
+	fields := allFields(m)
+	vd, err := newValidator(m.Fields)
+	if err != nil {
+		log.Error().Err(err).Msgf("Invalid validation rules for Module: %s", m.Name)
+		return nil
+	}
 
+	// without declared fields the collection stays schemaless
+	schemaless := len(m.Fields) == 0
+	output := func(doc bson.M, selected []config.Field) map[string]any {
+		if schemaless {
+			return doc
+		}
+		return mongoOutput(selected, doc)
+	}
+	// projection only when ?fields= is used, schemaless documents stay whole
+	projection := func(c *fiber.Ctx, selected []config.Field) bson.M {
+		if schemaless && c.Query("fields") == "" {
+			return nil
+		}
+		return mongoProjection(selected)
+	}
+
+	handlers := map[string]fiber.Handler{}
 	for _, op := range m.Operations {
-		switch strings.ToLower(op) {
-		case "create":
+		op = strings.ToLower(op)
+		switch op {
+		case config.OpCreate:
 			// ----------------------------
 			// CREATE (INSERT)
 			// ----------------------------
-			app.Post(baseRoute, func(c *fiber.Ctx) error {
+			handlers[op] = src.handler(func(c *fiber.Ctx, col *mongo.Collection, scopes rowScopes) error {
 				ctx := context.Background()
 
-				body := map[string]any{}
-				if err := c.BodyParser(&body); err != nil {
-					return utils.ResponseError(c, 400, err.Error())
+				body, err := parseBody(c)
+				if err != nil {
+					return utils.ResponseError(c, 400, "Invalid request body")
+				}
+				scopes.stamp(body)
+
+				doc, errs := mongoDocument(vd, body, true, schemaless)
+				if len(errs) > 0 {
+					return responseInvalid(c, errs)
 				}
 
 				id := uuid.New().String()
-				body["id"] = id
-				body["created_at"] = time.Now()
-				body["updated_at"] = time.Now()
-				_, err := col.InsertOne(ctx, body)
-				if err != nil {
+				now := time.Now()
+				for field, v := range scopes.insertColumns() {
+					doc[field] = v
+				}
+				doc["id"] = id
+				doc["created_at"] = now
+				doc["updated_at"] = now
+				if _, err := col.InsertOne(ctx, doc); err != nil {
 					return utils.ResponseError(c, 500, err.Error())
 				}
 				return utils.ResponseSuccess(c, fiber.Map{"id": id}, "Data has been created")
 			})
-			log.Info().Msgf("Add Route POST %s", baseRoute)
-		case "read_list":
+		case config.OpReadList:
 			// ----------------------------
 			// GET ALL
 			// ----------------------------
-			app.Get(baseRoute, func(c *fiber.Ctx) error {
+			handlers[op] = src.handler(func(c *fiber.Ctx, col *mongo.Collection, scopes rowScopes) error {
 				ctx := context.Background()
 
-				cursor, err := col.Find(ctx, bson.M{})
+				params, err := parseListParams(c, fields, m.Pagination)
+				if err != nil {
+					return utils.ResponseError(c, 400, err.Error())
+				}
+
+				filters, err := parseFilters(c, fields)
+				if err != nil {
+					return utils.ResponseError(c, 400, err.Error())
+				}
+
+				selected, err := parseProjection(c, fields)
+				if err != nil {
+					return utils.ResponseError(c, 400, err.Error())
+				}
+				// the cursor is built from the sort key, so it is always read
+				queryFields := selected
+				if params.Cursor {
+					queryFields = withField(selected, params.SortField)
+				}
+
+				var total int64
+				limit, offset := params.Limit, params.offset()
+				keyset := []bson.M{}
+				if params.Cursor {
+					// keyset: no count, fetch one extra document to know if there is a next page
+					if params.After != nil {
+						keyset = append(keyset, mongoKeyset(params.SortField, params.After))
+					}
+					limit, offset = limit+1, 0
+				}
+
+				filter := scopes.mongoFilter(mongoFilter(filters, keyset...))
+				if !params.Cursor {
+					total, err = col.CountDocuments(ctx, filter)
+					if err != nil {
+						return utils.ResponseError(c, 500, err.Error())
+					}
+				}
+
+				opts := options.Find().
+					SetSort(mongoSort(params.Sort)).
+					SetSkip(int64(offset)).
+					SetLimit(int64(limit))
+				if p := projection(c, queryFields); p != nil {
+					opts.SetProjection(p)
+				}
+
+				cursor, err := col.Find(ctx, filter, opts)
 				if err != nil {
 					return utils.ResponseError(c, 500, err.Error())
 				}
 				defer cursor.Close(ctx)
 
-				results := []bson.M{}
-				if err := cursor.All(ctx, &results); err != nil {
+				docs := []bson.M{}
+				if err := cursor.All(ctx, &docs); err != nil {
 					return utils.ResponseError(c, 500, err.Error())
 				}
 
-				return utils.ResponseSuccess(c, results, "Data has been created")
+				results := make([]map[string]any, len(docs))
+				for i, doc := range docs {
+					results[i] = output(doc, queryFields)
+				}
+
+				if params.Cursor {
+					results, meta, err := cursorPage(cursorSecret(m.Pagination), params, results, selected)
+					if err != nil {
+						return utils.ResponseError(c, 500, err.Error())
+					}
+					return utils.ResponseSuccessMeta(c, results, meta, "Successfully read data")
+				}
+
+				return utils.ResponseSuccessMeta(c, results, newPageMeta(params, total), "Successfully read data")
 			})
-			log.Info().Msgf("Add Route GET %s", baseRoute)
-		case "read_single":
+		case config.OpReadSingle:
 			// ----------------------------
 			// GET by ID
 			// ----------------------------
-			app.Get(baseRoute+"/:id", func(c *fiber.Ctx) error {
+			handlers[op] = src.handler(func(c *fiber.Ctx, col *mongo.Collection, scopes rowScopes) error {
 				ctx := context.Background()
 				id := c.Params("id")
 
+				selected, err := parseProjection(c, fields)
+				if err != nil {
+					return utils.ResponseError(c, 400, err.Error())
+				}
+
+				opts := options.FindOne()
+				if p := projection(c, selected); p != nil {
+					opts.SetProjection(p)
+				}
+
 				result := bson.M{}
-				err := col.FindOne(ctx, bson.M{"id": id}).Decode(&result)
+				err = col.FindOne(ctx, scopes.mongoFilter(bson.M{"id": id}), opts).Decode(&result)
 				if err == mongo.ErrNoDocuments {
 					return utils.ResponseError(c, 404, "Data not found")
 				}
@@ -331,70 +524,87 @@ func registerModuleMongo(app *fiber.App, authMiddleware fiber.Handler, baseRoute
 					return utils.ResponseError(c, 500, err.Error())
 				}
 
-				return utils.ResponseSuccess(c, result, "Successfully read data")
+				return utils.ResponseSuccess(c, output(result, selected), "Successfully read data")
 			})
-			log.Info().Msgf("Add Route GET %s", baseRoute+"/:id")
-		case "update":
+		case config.OpUpdate:
 			// ----------------------------
 			// UPDATE
 			// ----------------------------
-			app.Patch(baseRoute+"/:id", func(c *fiber.Ctx) error {
+			handlers[op] = src.handler(func(c *fiber.Ctx, col *mongo.Collection, scopes rowScopes) error {
 				ctx := context.Background()
 
 				// Parse ID from URL
 				id := c.Params("id")
 
 				// Parse request body into dynamic map
-				body := map[string]any{}
-				if err := c.BodyParser(&body); err != nil {
+				body, err := parseBody(c)
+				if err != nil {
 					return utils.ResponseError(c, 400, "Invalid JSON Body")
 				}
 
 				// Prevent updating primary key fields
 				delete(body, "_id")
 				delete(body, "id")
+				scopes.protect(body)
+
+				doc, errs := mongoDocument(vd, body, false, schemaless)
+				if len(errs) > 0 {
+					return responseInvalid(c, errs)
+				}
 
 				// If body is empty, do nothing
-				if len(body) == 0 {
+				if len(doc) == 0 {
 					return utils.ResponseError(c, 400, "No fields to update")
 				}
 
 				// Add updated_at automatically (optional)
-				body["updated_at"] = time.Now()
-
-				b, _ := json.Marshal(body)
-				jsonString := string(b)
-
-				log.Info().Msg(id)
-				log.Info().Msg(jsonString)
+				doc["updated_at"] = time.Now()
 
 				// Do partial update with $set
-				filter := bson.M{"id": id}
-				_, err := col.UpdateOne(ctx, filter, bson.M{"$set": body})
-				if err != nil {
+				filter := scopes.mongoFilter(bson.M{"id": id})
+				if _, err := col.UpdateOne(ctx, filter, bson.M{"$set": doc}); err != nil {
 					return utils.ResponseError(c, 500, err.Error())
 				}
 				return utils.ResponseSuccess(c, fiber.Map{"updated": true}, "Successfully update data")
 			})
-			log.Info().Msgf("Add Route PATCH %s", baseRoute+"/:id")
-		case "delete":
+		case config.OpDelete:
 			// ----------------------------
 			// DELETE
 			// ----------------------------
-			app.Delete(baseRoute+"/:id", func(c *fiber.Ctx) error {
+			handlers[op] = src.handler(func(c *fiber.Ctx, col *mongo.Collection, scopes rowScopes) error {
 				ctx := context.Background()
 				id := c.Params("id")
 
-				_, err := col.DeleteOne(ctx, bson.M{"id": id})
+				_, err := col.DeleteOne(ctx, scopes.mongoFilter(bson.M{"id": id}))
 				if err != nil {
 					return utils.ResponseError(c, 500, err.Error())
 				}
 
 				return utils.ResponseSuccess(c, fiber.Map{"deleted": true}, "Successfully delete data")
 			})
-			log.Info().Msgf("Add Route DELETE %s", baseRoute+"/:id")
 		default:
 			log.Info().Msgf("Invalid Operation for Module: %s", m.Name)
 		}
 	}
+	return handlers
+}
+
+// mongoDocument coerces the body into a document. Schemaless modules
+// store the body as sent, only numbers are normalized.
+func mongoDocument(vd *validator, body map[string]any, create, schemaless bool) (bson.M, fieldErrors) {
+	doc := bson.M{}
+	if schemaless {
+		for k, v := range body {
+			doc[k] = normalizeJSONNumbers(v)
+		}
+		return doc, nil
+	}
+
+	values, errs := vd.values(body, create)
+	for _, f := range vd.fields {
+		if v, ok := values[f.Name]; ok {
+			doc[f.Name] = mongoValue(f, v)
+		}
+	}
+	return doc, errs
 }
@@ -0,0 +1,38 @@
+package modules
+
+import (
+	"slices"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/cunkz/goyummy/bin/config"
+	"github.com/cunkz/goyummy/bin/helpers/auth"
+)
+
+var errNoOwner = &requestError{fiber.StatusForbidden, "the caller has no subject to own rows"}
+
+// owner scopes the rows of a module with owner_field to the caller
+type owner struct {
+	field  string
+	bypass []string
+}
+
+func newOwner(m config.Module) *owner {
+	if m.OwnerField == "" {
+		return nil
+	}
+	return &owner{field: m.OwnerField, bypass: m.OwnerBypass}
+}
+
+// scope reads the caller of c, callers with a bypass role see every row
+func (o *owner) scope(c *fiber.Ctx) (*rowScope, error) {
+	p := auth.GetPrincipal(c)
+	if p == nil || p.Subject == "" {
+		return nil, errNoOwner
+	}
+	return &rowScope{
+		field: o.field,
+		value: p.Subject,
+		all:   slices.ContainsFunc(o.bypass, p.HasRole),
+	}, nil
+}
@@ -0,0 +1,250 @@
+package modules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/cunkz/goyummy/bin/config"
+	"github.com/cunkz/goyummy/bin/helpers/db"
+)
+
+// Default order of read_list when no sort is requested
+const defaultSortField = "created_at"
+
+type sortField struct {
+	Name string
+	Desc bool
+}
+
+// listParams are the read_list query parameters shared by every engine
+type listParams struct {
+	Page  int
+	Limit int
+	Sort  []sortField
+
+	// cursor mode: Sort is one key plus id, After is the decoded cursor
+	Cursor    bool
+	SortField config.Field
+	After     *cursorPosition
+}
+
+func (p listParams) offset() int {
+	return (p.Page - 1) * p.Limit
+}
+
+type pageMeta struct {
+	Page       int   `json:"page"`
+	Limit      int   `json:"limit"`
+	Total      int64 `json:"total"`
+	TotalPages int64 `json:"total_pages"`
+}
+
+func newPageMeta(p listParams, total int64) pageMeta {
+	pages := int64(0)
+	if p.Limit > 0 {
+		pages = (total + int64(p.Limit) - 1) / int64(p.Limit)
+	}
+	return pageMeta{Page: p.Page, Limit: p.Limit, Total: total, TotalPages: pages}
+}
+
+// parseListParams reads page, limit and sort. Sorting is only allowed on
+// declared fields and always ends with id so pages are stable.
+func parseListParams(c *fiber.Ctx, fields []config.Field, pg config.Pagination) (listParams, error) {
+	defaultLimit := pg.DefaultLimit
+	if defaultLimit <= 0 {
+		defaultLimit = config.DefaultPageLimit
+	}
+	maxLimit := pg.MaxLimit
+	if maxLimit <= 0 {
+		maxLimit = config.DefaultMaxLimit
+	}
+	if defaultLimit > maxLimit {
+		defaultLimit = maxLimit
+	}
+
+	p := listParams{Page: 1, Limit: defaultLimit}
+
+	if v := c.Query("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return p, fmt.Errorf("page must be a positive integer")
+		}
+		p.Page = n
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return p, fmt.Errorf("limit must be a positive integer")
+		}
+		p.Limit = min(n, maxLimit)
+	}
+
+	cursorMode := pg.Mode == config.PaginationCursor
+	if cursorMode && c.Query("page") != "" {
+		return p, fmt.Errorf("page is not supported with cursor pagination, use cursor")
+	}
+
+	known := map[string]config.Field{}
+	for _, f := range fields {
+		known[f.Name] = f
+	}
+
+	seen := map[string]bool{}
+	for _, s := range strings.Split(c.Query("sort"), ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		sf := sortField{Name: s}
+		if strings.HasPrefix(s, "-") {
+			sf = sortField{Name: s[1:], Desc: true}
+		} else if strings.HasPrefix(s, "+") {
+			sf.Name = s[1:]
+		}
+		if _, ok := known[sf.Name]; !ok {
+			return p, fmt.Errorf("cannot sort by unknown field %s", sf.Name)
+		}
+		if seen[sf.Name] {
+			continue
+		}
+		seen[sf.Name] = true
+		p.Sort = append(p.Sort, sf)
+	}
+
+	if len(p.Sort) == 0 {
+		p.Sort = append(p.Sort, sortField{Name: defaultSortField})
+		seen[defaultSortField] = true
+	}
+
+	if cursorMode {
+		return withCursor(c, p, known, pg)
+	}
+
+	if !seen["id"] {
+		p.Sort = append(p.Sort, sortField{Name: "id"})
+	}
+
+	return p, nil
+}
+
+// withCursor restricts sorting to one key, id breaks ties in the same
+// direction so (key, id) can be compared as a row.
+func withCursor(c *fiber.Ctx, p listParams, known map[string]config.Field, pg config.Pagination) (listParams, error) {
+	p.Cursor = true
+
+	key := p.Sort[0]
+	if len(p.Sort) > 1 && !(len(p.Sort) == 2 && p.Sort[1].Name == "id" && p.Sort[1].Desc == key.Desc) {
+		return p, fmt.Errorf("cursor pagination supports a single sort field")
+	}
+	p.SortField = known[key.Name]
+	if p.SortField.Nullable {
+		return p, fmt.Errorf("cannot use cursor pagination on nullable field %s", key.Name)
+	}
+
+	p.Sort = []sortField{key}
+	if key.Name != "id" {
+		p.Sort = append(p.Sort, sortField{Name: "id", Desc: key.Desc})
+	}
+
+	if v := c.Query("cursor"); v != "" {
+		pos, err := decodeCursor(cursorSecret(pg), v, p.SortField)
+		if err != nil {
+			return p, err
+		}
+		if pos.Desc != key.Desc {
+			return p, fmt.Errorf("cursor was issued for a different sort direction")
+		}
+		p.After = pos
+	}
+
+	return p, nil
+}
+
+// parseProjection reads ?fields=a,b and returns the selected fields in
+// declaration order. id is always part of the result.
+func parseProjection(c *fiber.Ctx, fields []config.Field) ([]config.Field, error) {
+	raw := c.Query("fields")
+	if strings.TrimSpace(raw) == "" {
+		return fields, nil
+	}
+
+	known := map[string]bool{}
+	for _, f := range fields {
+		known[f.Name] = true
+	}
+
+	wanted := map[string]bool{"id": true}
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !known[name] {
+			return nil, fmt.Errorf("unknown field %s", name)
+		}
+		wanted[name] = true
+	}
+
+	selected := []config.Field{}
+	for _, f := range fields {
+		if wanted[f.Name] {
+			selected = append(selected, f)
+		}
+	}
+	return selected, nil
+}
+
+// withField appends f to fields unless it is already there
+func withField(fields []config.Field, f config.Field) []config.Field {
+	for _, e := range fields {
+		if e.Name == f.Name {
+			return fields
+		}
+	}
+	return append(append([]config.Field{}, fields...), f)
+}
+
+// -------------------------------------
+// SQL
+// -------------------------------------
+
+func sqlOrderBy(d db.Dialect, sort []sortField) string {
+	parts := make([]string, len(sort))
+	for i, s := range sort {
+		parts[i] = d.Quote(s.Name)
+		if s.Desc {
+			parts[i] += " DESC"
+		} else {
+			parts[i] += " ASC"
+		}
+	}
+	return " ORDER BY " + strings.Join(parts, ", ")
+}
+
+// -------------------------------------
+// MONGO
+// -------------------------------------
+
+func mongoProjection(fields []config.Field) bson.M {
+	p := bson.M{"_id": 0}
+	for _, f := range fields {
+		p[f.Name] = 1
+	}
+	return p
+}
+
+func mongoSort(sort []sortField) bson.D {
+	d := bson.D{}
+	for _, s := range sort {
+		dir := 1
+		if s.Desc {
+			dir = -1
+		}
+		d = append(d, bson.E{Key: s.Name, Value: dir})
+	}
+	return d
+}
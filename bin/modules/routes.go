@@ -0,0 +1,72 @@
+package modules
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cunkz/goyummy/bin/config"
+	"github.com/cunkz/goyummy/bin/helpers/utils"
+)
+
+// Route is one endpoint generated from a module operation
+type Route struct {
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Module    string `json:"module"`
+	Operation string `json:"operation"`
+	Engine    string `json:"engine"`
+	Auth      string `json:"auth,omitempty"`
+
+	Require *config.Requirement `json:"require,omitempty"`
+}
+
+// HTTP method and path suffix of every operation
+var operationRoutes = map[string]struct {
+	Method string
+	Suffix string
+}{
+	config.OpCreate:     {"POST", ""},
+	config.OpReadList:   {"GET", ""},
+	config.OpReadSingle: {"GET", "/:id"},
+	config.OpUpdate:     {"PATCH", "/:id"},
+	config.OpDelete:     {"DELETE", "/:id"},
+}
+
+func moduleBaseRoute(m config.Module) string {
+	return fmt.Sprintf("/api/%s/v1", utils.ToSlug(m.Name))
+}
+
+// Routes lists the endpoints the recipe generates, without touching any
+// database. Unknown operations are skipped.
+func Routes(cfg *config.AppConfig) []Route {
+	routes := []Route{}
+	for _, m := range cfg.Modules {
+		routes = append(routes, moduleRoutes(cfg, m)...)
+	}
+	return append(routes, tokenRoutes(cfg)...)
+}
+
+func moduleRoutes(cfg *config.AppConfig, m config.Module) []Route {
+	routes := []Route{}
+	base := moduleBaseRoute(m)
+	for _, op := range m.Operations {
+		op = strings.ToLower(op)
+		r, ok := operationRoutes[op]
+		if !ok {
+			continue
+		}
+		route := Route{
+			Method:    r.Method,
+			Path:      base + r.Suffix,
+			Module:    m.Name,
+			Operation: op,
+			Engine:    config.GetDBEngineByName(cfg, m.Database),
+			Auth:      m.Auth.For(op),
+		}
+		if req := m.Require.For(op); !req.IsZero() && route.Auth != "" {
+			route.Require = &req
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
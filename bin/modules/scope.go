@@ -0,0 +1,100 @@
+package modules
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/cunkz/goyummy/bin/helpers/utils"
+)
+
+// requestError is answered to the client as it is
+type requestError struct {
+	status  int
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+// responseRequestError answers err when it is a *requestError
+func responseRequestError(c *fiber.Ctx, err error) (error, bool) {
+	var re *requestError
+	if errors.As(err, &re) {
+		return utils.ResponseError(c, re.status, re.message), true
+	}
+	return err, false
+}
+
+// rowScope restricts a request to the rows where field is value
+type rowScope struct {
+	field string
+	value string
+	// all: the caller bypasses the scope
+	all bool
+	// system: the column is managed here, not a field of the module
+	system bool
+}
+
+// rowScopes apply together: the tenant, then the owner
+type rowScopes []*rowScope
+
+// stamp sets the scoped fields of a new row. Bypassing callers may
+// create rows for someone else.
+func (scopes rowScopes) stamp(body map[string]any) {
+	for _, s := range scopes {
+		if _, set := body[s.field]; s.all && set {
+			continue
+		}
+		if s.system {
+			// set by insertColumns, never from the body
+			delete(body, s.field)
+			continue
+		}
+		body[s.field] = s.value
+	}
+}
+
+// protect drops the scoped fields from an update, only bypassing callers
+// move rows
+func (scopes rowScopes) protect(body map[string]any) {
+	for _, s := range scopes {
+		if !s.all {
+			delete(body, s.field)
+		}
+	}
+}
+
+// insertColumns are the system columns to add to a new row
+func (scopes rowScopes) insertColumns() map[string]any {
+	cols := map[string]any{}
+	for _, s := range scopes {
+		if s.system {
+			cols[s.field] = s.value
+		}
+	}
+	return cols
+}
+
+func (scopes rowScopes) sqlWhere(w *sqlWhere) {
+	for _, s := range scopes {
+		if !s.all {
+			w.add(w.dialect.Quote(s.field) + "=" + w.arg(s.value))
+		}
+	}
+}
+
+func (scopes rowScopes) mongoFilter(filter bson.M) bson.M {
+	and := []bson.M{filter}
+	for _, s := range scopes {
+		if !s.all {
+			and = append(and, bson.M{s.field: s.value})
+		}
+	}
+	if len(and) == 1 {
+		return filter
+	}
+	return bson.M{"$and": and}
+}
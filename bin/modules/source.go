@@ -0,0 +1,226 @@
+package modules
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/cunkz/goyummy/bin/config"
+	"github.com/cunkz/goyummy/bin/helpers/auth"
+	"github.com/cunkz/goyummy/bin/helpers/db"
+)
+
+// scoper reads the tenant and the owner of a request
+type scoper struct {
+	owner   *owner
+	tenancy *tenancy
+}
+
+func newScoper(cfg *config.AppConfig, m config.Module) scoper {
+	return scoper{owner: newOwner(m), tenancy: newTenancy(cfg, m)}
+}
+
+func (s scoper) scopes(c *fiber.Ctx) (rowScopes, string, error) {
+	var scopes rowScopes
+	tenant := ""
+	if s.tenancy != nil {
+		var err error
+		if tenant, err = s.tenancy.tenant(c); err != nil {
+			return nil, "", err
+		}
+		if scope := s.tenancy.scope(tenant); scope != nil {
+			scopes = append(scopes, scope)
+		}
+	}
+	if s.owner != nil {
+		scope, err := s.owner.scope(c)
+		if err != nil {
+			return nil, "", err
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, tenant, nil
+}
+
+// fixedDatabase tells if every request uses the database of the module
+func (s scoper) fixedDatabase() bool {
+	return s.tenancy == nil || s.tenancy.cfg.Mode != config.TenancyDatabase
+}
+
+// -------------------------------------
+// SQL
+// -------------------------------------
+
+// sqlQuerier runs the queries of a handler, on the database or on the
+// transaction of the request
+type sqlQuerier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+type sqlHandler func(c *fiber.Ctx, q sqlQuerier, scopes rowScopes) error
+
+// sqlSource picks the database of each request. Settings of the request,
+// the search_path of a tenant schema and the session settings of the
+// database, are applied in a transaction so they never leak to other
+// requests of the pool.
+type sqlSource struct {
+	scoper
+	reg      *db.Registry
+	engine   string
+	database string
+	dialect  db.Dialect
+	session  map[string]string
+}
+
+func newSQLSource(cfg *config.AppConfig, reg *db.Registry, m config.Module, engine string) *sqlSource {
+	s := &sqlSource{scoper: newScoper(cfg, m), reg: reg, engine: engine, database: m.Database, dialect: db.GetDialect(engine)}
+	if d := config.FindDatabase(cfg, m.Database); d != nil {
+		s.session = d.Session
+	}
+	return s
+}
+
+func (s *sqlSource) handler(run sqlHandler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := s.serve(c, run)
+		if err, ok := responseRequestError(c, err); ok {
+			return err
+		}
+		return err
+	}
+}
+
+func (s *sqlSource) serve(c *fiber.Ctx, run sqlHandler) error {
+	scopes, tenant, err := s.scopes(c)
+	if err != nil {
+		return err
+	}
+
+	conn := s.reg.SQL(s.engine, s.tenancy.database(s.database, tenant))
+	if conn == nil {
+		if !s.fixedDatabase() {
+			return errUnknownTenant
+		}
+		return fmt.Errorf("database %s is not connected", s.database)
+	}
+
+	settings := sessionSettings(c, s.session, tenant)
+	if schema := s.tenancy.schema(tenant); schema != "" {
+		settings["search_path"] = s.dialect.Quote(schema)
+	}
+	if len(settings) == 0 {
+		return run(c, conn, scopes)
+	}
+
+	tx, err := conn.BeginTx(c.Context(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for name, value := range settings {
+		// is_local: the setting ends with the transaction
+		if _, err := tx.Exec("SELECT set_config($1, $2, true)", name, value); err != nil {
+			return err
+		}
+	}
+	if err := run(c, tx, scopes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// sessionSettings reads the session settings of a request from its
+// principal. Settings without a value are set empty, never left to a
+// previous request.
+func sessionSettings(c *fiber.Ctx, session map[string]string, tenant string) map[string]string {
+	settings := map[string]string{}
+	p := auth.GetPrincipal(c)
+	for name, source := range session {
+		value := ""
+		switch {
+		case source == config.SessionTenant:
+			value = tenant
+		case p == nil:
+		case source == config.SessionSubject:
+			value = p.Subject
+		case source == config.SessionRoles:
+			value = strings.Join(p.Roles, ",")
+		case source == config.SessionScopes:
+			value = strings.Join(p.Scopes, ",")
+		case source == config.SessionAuth:
+			value = p.Auth
+		case strings.HasPrefix(source, config.SessionClaimPrefix):
+			if v, ok := auth.ClaimValue(p.Claims, strings.TrimPrefix(source, config.SessionClaimPrefix)); ok {
+				value = settingValue(v)
+			}
+		}
+		settings[name] = value
+	}
+	return settings
+}
+
+// settingValue writes a claim as text: lists comma separated, objects as
+// json
+func settingValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = settingValue(item)
+		}
+		return strings.Join(items, ",")
+	case map[string]any:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
+
+// -------------------------------------
+// MONGO
+// -------------------------------------
+
+type mongoHandler func(c *fiber.Ctx, col *mongo.Collection, scopes rowScopes) error
+
+// mongoSource picks the collection of each request
+type mongoSource struct {
+	scoper
+	reg      *db.Registry
+	database string
+	table    string
+}
+
+func (s *mongoSource) handler(run mongoHandler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := s.serve(c, run)
+		if err, ok := responseRequestError(c, err); ok {
+			return err
+		}
+		return err
+	}
+}
+
+func (s *mongoSource) serve(c *fiber.Ctx, run mongoHandler) error {
+	scopes, tenant, err := s.scopes(c)
+	if err != nil {
+		return err
+	}
+	mdb := s.reg.MongoDB(s.tenancy.database(s.database, tenant))
+	if mdb == nil {
+		if !s.fixedDatabase() {
+			return errUnknownTenant
+		}
+		return fmt.Errorf("database %s is not connected", s.database)
+	}
+	return run(c, mdb.Collection(s.table), scopes)
+}
@@ -0,0 +1,120 @@
+package modules
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/cunkz/goyummy/bin/config"
+	"github.com/cunkz/goyummy/bin/helpers/auth"
+)
+
+var (
+	errNoTenant      = &requestError{fiber.StatusBadRequest, "missing or invalid tenant"}
+	errUnknownTenant = &requestError{fiber.StatusNotFound, "unknown tenant"}
+	errOtherTenant   = &requestError{fiber.StatusForbidden, "tenant does not match the caller"}
+)
+
+// tenancy reads the tenant of the requests of a module
+type tenancy struct {
+	cfg   *config.Tenancy
+	known map[string]bool
+}
+
+// newTenancy returns nil when m is not scoped to tenants
+func newTenancy(cfg *config.AppConfig, m config.Module) *tenancy {
+	if !cfg.Tenancy.Scopes(m) {
+		return nil
+	}
+	return tenancyOf(cfg)
+}
+
+func tenancyOf(cfg *config.AppConfig) *tenancy {
+	t := &tenancy{cfg: cfg.Tenancy, known: map[string]bool{}}
+	for _, tenant := range cfg.Tenancy.Tenants {
+		t.known[tenant] = true
+	}
+	return t
+}
+
+// loginTenant is the tenant a login is made under, written in the tokens
+// so their callers keep reaching it. "" when the request names none, or
+// when the tenant is read from the tokens themselves.
+func loginTenant(cfg *config.AppConfig, c *fiber.Ctx) (string, error) {
+	if cfg.Tenancy == nil || cfg.Tenancy.From == config.TenantFromClaim {
+		return "", nil
+	}
+	t := tenancyOf(cfg)
+	if t.requested(c) == "" {
+		return "", nil
+	}
+	return t.tenant(c)
+}
+
+func (t *tenancy) tenant(c *fiber.Ctx) (string, error) {
+	tenant := t.requested(c)
+	if !config.TenantIDRe.MatchString(tenant) {
+		return "", errNoTenant
+	}
+	if len(t.known) > 0 && !t.known[tenant] {
+		return "", errUnknownTenant
+	}
+	// anyone can send a header or call any subdomain, an authenticated
+	// caller only reaches the tenant of its claim
+	if p := auth.GetPrincipal(c); p != nil && t.cfg.From != config.TenantFromClaim {
+		v, _ := auth.ClaimValue(p.Claims, t.cfg.ClaimPath())
+		if claim, _ := v.(string); claim != tenant {
+			return "", errOtherTenant
+		}
+	}
+	return tenant, nil
+}
+
+// requested is the tenant named by the request, unchecked
+func (t *tenancy) requested(c *fiber.Ctx) string {
+	var tenant string
+	switch t.cfg.From {
+	case config.TenantFromHeader:
+		tenant = c.Get(t.cfg.HeaderName())
+	case config.TenantFromSubdomain:
+		host := strings.ToLower(c.Hostname())
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		// only the label right below the domain, never a.b.domain
+		if sub, ok := strings.CutSuffix(host, "."+strings.ToLower(t.cfg.Domain)); ok && !strings.Contains(sub, ".") {
+			tenant = sub
+		}
+	case config.TenantFromClaim:
+		if p := auth.GetPrincipal(c); p != nil {
+			v, _ := auth.ClaimValue(p.Claims, t.cfg.ClaimPath())
+			tenant, _ = v.(string)
+		}
+	}
+	return tenant
+}
+
+// scope is the tenant column predicate, nil in the other modes
+func (t *tenancy) scope(tenant string) *rowScope {
+	if t.cfg.Mode != config.TenancyColumn {
+		return nil
+	}
+	return &rowScope{field: t.cfg.ColumnName(), value: tenant, system: true}
+}
+
+// database is the database of tenant standing for database
+func (t *tenancy) database(database, tenant string) string {
+	if t == nil || t.cfg.Mode != config.TenancyDatabase {
+		return database
+	}
+	return t.cfg.DatabaseFor(database, tenant)
+}
+
+// schema is the Postgres schema of tenant, "" outside schema mode
+func (t *tenancy) schema(tenant string) string {
+	if t == nil || t.cfg.Mode != config.TenancySchema {
+		return ""
+	}
+	return t.cfg.SchemaFor(tenant)
+}
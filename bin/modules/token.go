@@ -0,0 +1,372 @@
+package modules
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/cunkz/goyummy/bin/config"
+	"github.com/cunkz/goyummy/bin/helpers/auth"
+	"github.com/cunkz/goyummy/bin/helpers/db"
+	"github.com/cunkz/goyummy/bin/helpers/utils"
+)
+
+// Operations of the token endpoints of a jwt auth with jwt_issue
+const (
+	opToken   = "token"
+	opRefresh = "refresh"
+	opRevoke  = "revoke"
+)
+
+var errUserNotFound = errors.New("user not found")
+
+// tokenRoutes lists the endpoints of every auth issuing tokens
+func tokenRoutes(cfg *config.AppConfig) []Route {
+	routes := []Route{}
+	for _, a := range cfg.Auths {
+		if a.Type == config.AuthJWT && a.JWTIssue != nil {
+			routes = append(routes, issueRoutes(cfg, a)...)
+		}
+	}
+	return routes
+}
+
+// issueRoutes are public: the caller proves who it is with credentials or
+// a token in the body.
+func issueRoutes(cfg *config.AppConfig, a config.Auth) []Route {
+	base := a.JWTIssue.BasePath(a.Name)
+	engine := config.GetDBEngineByName(cfg, a.JWTIssue.Denylist.Database)
+	routes := []Route{}
+	for _, op := range []string{opToken, opRefresh, opRevoke} {
+		routes = append(routes, Route{Method: "POST", Path: base + "/" + op, Operation: op, Engine: engine})
+	}
+	return routes
+}
+
+// tokenHandlers builds the handlers of the token endpoints of auth a
+func tokenHandlers(cfg *config.AppConfig, reg *db.Registry, a config.Auth, issuer *auth.Issuer) (map[string]fiber.Handler, error) {
+	t := a.JWTIssue
+
+	var err error
+	var findUser userFinder
+	u := config.TokenUsers{}
+	if t.Users != nil {
+		u = *t.Users
+		if findUser, err = newUserFinder(cfg, reg, u); err != nil {
+			return nil, fmt.Errorf("auth %s: %w", a.Name, err)
+		}
+	}
+	var basic *auth.BasicUsers
+	if t.Basic != "" {
+		if b := config.FindAuth(cfg, t.Basic); b != nil {
+			basic = auth.NewBasicUsers(*b)
+		}
+	}
+
+	// claims of the subject of a refresh token as they are now, looked up
+	// in the same order as a login
+	reload := func(c *fiber.Ctx, subject string) (map[string]any, error) {
+		if basic != nil {
+			if p, ok := basic.Lookup(subject); ok {
+				return basicClaims(a, p), nil
+			}
+		}
+		if findUser == nil {
+			return nil, auth.ErrInvalidToken
+		}
+		user, err := findUser(c, "id", subject)
+		if errors.Is(err, errUserNotFound) {
+			return nil, auth.ErrInvalidToken
+		}
+		if err != nil {
+			return nil, err
+		}
+		return user.claims, nil
+	}
+
+	return map[string]fiber.Handler{
+		// ----------------------------
+		// LOGIN: credentials for tokens
+		// ----------------------------
+		opToken: func(c *fiber.Ctx) error {
+			username, password, err := credentials(c)
+			if err != nil {
+				return utils.ResponseError(c, 400, err.Error())
+			}
+
+			tenant, err := loginTenant(cfg, c)
+			if err != nil {
+				err, _ = responseRequestError(c, err)
+				return err
+			}
+
+			subject, claims := "", map[string]any{}
+			var p *auth.Principal
+			if basic != nil {
+				p, _ = basic.Check(username, password)
+			}
+			switch {
+			case p != nil:
+				subject, claims = p.Subject, basicClaims(a, p)
+			case findUser != nil:
+				user, err := findUser(c, u.UsernameFieldName(), username)
+				if errors.Is(err, errUserNotFound) {
+					auth.CheckNoUser(password)
+					return utils.ResponseError(c, 401, "invalid username or password")
+				}
+				if err != nil {
+					err, _ = responseRequestError(c, err)
+					return err
+				}
+				if !auth.CheckPassword(user.hash, password) {
+					return utils.ResponseError(c, 401, "invalid username or password")
+				}
+				subject, claims = user.id, user.claims
+			default:
+				return utils.ResponseError(c, 401, "invalid username or password")
+			}
+
+			if tenant != "" {
+				auth.SetClaimValue(claims, cfg.Tenancy.ClaimPath(), tenant)
+			}
+			pair, err := issuer.Issue(subject, claims)
+			if err != nil {
+				return err
+			}
+			return utils.ResponseSuccess(c, pair, "Token has been issued")
+		},
+		// ----------------------------
+		// REFRESH: single use refresh token for a new pair
+		// ----------------------------
+		opRefresh: func(c *fiber.Ctx) error {
+			raw, err := bodyToken(c, "refresh_token")
+			if err != nil {
+				return utils.ResponseError(c, 400, err.Error())
+			}
+			pair, err := issuer.Refresh(c.Context(), raw, func(_ context.Context, subject string, old map[string]any) (map[string]any, error) {
+				// a token is only refreshed under the tenant it was issued for
+				tenant, err := loginTenant(cfg, c)
+				if err != nil {
+					return nil, err
+				}
+				if cfg.Tenancy != nil && cfg.Tenancy.From != config.TenantFromClaim {
+					v, _ := auth.ClaimValue(old, cfg.Tenancy.ClaimPath())
+					if issued, _ := v.(string); issued != tenant {
+						return nil, errOtherTenant
+					}
+				}
+				claims, err := reload(c, subject)
+				if err != nil || tenant == "" {
+					return claims, err
+				}
+				auth.SetClaimValue(claims, cfg.Tenancy.ClaimPath(), tenant)
+				return claims, nil
+			})
+			if errors.Is(err, auth.ErrInvalidToken) {
+				return utils.ResponseError(c, 401, err.Error())
+			}
+			if err != nil {
+				err, _ = responseRequestError(c, err)
+				return err
+			}
+			return utils.ResponseSuccess(c, pair, "Token has been refreshed")
+		},
+		// ----------------------------
+		// REVOKE: access or refresh token on the denylist
+		// ----------------------------
+		opRevoke: func(c *fiber.Ctx) error {
+			raw, err := bodyToken(c, "token")
+			if err != nil {
+				return utils.ResponseError(c, 400, err.Error())
+			}
+			err = issuer.Revoke(c.Context(), raw)
+			if errors.Is(err, auth.ErrInvalidToken) {
+				return utils.ResponseError(c, 401, err.Error())
+			}
+			if err != nil {
+				return err
+			}
+			return utils.ResponseSuccess(c, fiber.Map{"revoked": true}, "Token has been revoked")
+		},
+	}, nil
+}
+
+// basicClaims carries the roles of a basic user
+func basicClaims(a config.Auth, p *auth.Principal) map[string]any {
+	claims := map[string]any{}
+	if len(p.Roles) > 0 {
+		auth.SetClaimValue(claims, a.RolesClaimPath(), p.Roles)
+	}
+	return claims
+}
+
+// credentials reads username and password from the body or a Basic
+// Authorization header
+func credentials(c *fiber.Ctx) (string, string, error) {
+	header := c.Get(fiber.HeaderAuthorization)
+	if scheme, _, _ := strings.Cut(header, " "); strings.EqualFold(scheme, "Basic") {
+		username, password, ok := auth.ParseBasic(header)
+		if !ok {
+			return "", "", errors.New("invalid basic credentials")
+		}
+		return username, password, nil
+	}
+
+	body, err := parseBody(c)
+	if err != nil {
+		return "", "", errors.New("Invalid request body")
+	}
+	username, _ := body["username"].(string)
+	password, _ := body["password"].(string)
+	if username == "" || password == "" {
+		return "", "", errors.New("username and password are required")
+	}
+	return username, password, nil
+}
+
+func bodyToken(c *fiber.Ctx, key string) (string, error) {
+	body, err := parseBody(c)
+	if err != nil {
+		return "", errors.New("Invalid request body")
+	}
+	raw, _ := body[key].(string)
+	if raw == "" {
+		return "", fmt.Errorf("%s is required", key)
+	}
+	return raw, nil
+}
+
+// -------------------------------------
+// Users module
+// -------------------------------------
+
+type tokenUser struct {
+	id     string
+	hash   string
+	claims map[string]any
+}
+
+// userFinder reads a user of the tenant of the request by the value of
+// one of its fields
+type userFinder func(c *fiber.Ctx, field, value string) (*tokenUser, error)
+
+// newUserFinder reads users by their username field, or by id on refresh,
+// from the table of the users module, whatever its engine. Lookups are
+// scoped to the tenant of the request as read_single is, there is no
+// caller yet to own rows.
+func newUserFinder(cfg *config.AppConfig, reg *db.Registry, u config.TokenUsers) (userFinder, error) {
+	m := findModule(cfg, u.Module)
+	if m == nil {
+		return nil, fmt.Errorf("unknown users module %s", u.Module)
+	}
+
+	// id, password, then the claim fields
+	byName := map[string]config.Field{}
+	for _, f := range append(append([]config.Field{}, m.Fields...), config.SystemFields...) {
+		byName[f.Name] = f
+	}
+	fields := []config.Field{byName["id"], byName[u.PasswordFieldName()]}
+	claimNames := []string{}
+	for claim, name := range u.Claims {
+		fields = append(fields, byName[name])
+		claimNames = append(claimNames, claim)
+	}
+
+	toUser := func(item map[string]any) *tokenUser {
+		user := &tokenUser{claims: map[string]any{}}
+		user.id, _ = item["id"].(string)
+		user.hash, _ = item[u.PasswordFieldName()].(string)
+		for i, claim := range claimNames {
+			user.claims[claim] = item[fields[i+2].Name]
+		}
+		return user
+	}
+	scope := scoper{tenancy: newTenancy(cfg, *m)}
+
+	engine := config.GetDBEngineByName(cfg, m.Database)
+	if engine == config.EngineMongo {
+		src := &mongoSource{scoper: scope, reg: reg, database: m.Database, table: m.Table}
+		if src.fixedDatabase() && reg.MongoDB(m.Database) == nil {
+			return nil, fmt.Errorf("database %s (mongo) of users module %s is not available", m.Database, m.Name)
+		}
+		return func(c *fiber.Ctx, field, value string) (*tokenUser, error) {
+			var user *tokenUser
+			err := src.serve(c, func(c *fiber.Ctx, col *mongo.Collection, scopes rowScopes) error {
+				var doc bson.M
+				err := col.FindOne(c.Context(), scopes.mongoFilter(bson.M{field: value})).Decode(&doc)
+				if errors.Is(err, mongo.ErrNoDocuments) {
+					return errUserNotFound
+				}
+				if err != nil {
+					return err
+				}
+				user = toUser(mongoOutput(fields, doc))
+				return nil
+			})
+			return user, err
+		}, nil
+	}
+
+	src := newSQLSource(cfg, reg, *m, engine)
+	src.scoper = scope
+	if src.dialect == nil || (src.fixedDatabase() && reg.SQL(engine, m.Database) == nil) {
+		return nil, fmt.Errorf("database %s (%s) of users module %s is not available", m.Database, engine, m.Name)
+	}
+	selectSQL := "SELECT " + strings.Join(db.QuoteAll(src.dialect, fieldNames(fields)), ",") +
+		" FROM " + src.dialect.Quote(m.Table)
+	return func(c *fiber.Ctx, field, value string) (*tokenUser, error) {
+		var user *tokenUser
+		err := src.serve(c, func(c *fiber.Ctx, q sqlQuerier, scopes rowScopes) error {
+			w := newSQLWhere(src.dialect)
+			w.add(src.dialect.Quote(field) + "=" + w.arg(value))
+			scopes.sqlWhere(w)
+			targets, item := newRowScanner(fields)
+			err := q.QueryRow(selectSQL+w.clause()+src.dialect.LimitOffset(1, 0), w.args...).Scan(targets...)
+			if errors.Is(err, sql.ErrNoRows) {
+				return errUserNotFound
+			}
+			if err != nil {
+				return err
+			}
+			user = toUser(item())
+			return nil
+		})
+		return user, err
+	}, nil
+}
+
+func findModule(cfg *config.AppConfig, name string) *config.Module {
+	for i := range cfg.Modules {
+		if cfg.Modules[i].Name == name {
+			return &cfg.Modules[i]
+		}
+	}
+	return nil
+}
+
+// registerTokenRoutes adds the endpoints of every auth issuing tokens
+func registerTokenRoutes(app *fiber.App, cfg *config.AppConfig, reg *db.Registry, issuers map[string]*auth.Issuer) error {
+	for _, a := range cfg.Auths {
+		if a.Type != config.AuthJWT || a.JWTIssue == nil {
+			continue
+		}
+		handlers, err := tokenHandlers(cfg, reg, a, issuers[a.Name])
+		if err != nil {
+			return err
+		}
+		for _, r := range issueRoutes(cfg, a) {
+			if err := registerRoute(app, r, handlers[r.Operation], nil); err != nil {
+				return err
+			}
+		}
+		log.Info().Msgf("Token endpoints of %s at %s", a.Name, a.JWTIssue.BasePath(a.Name))
+	}
+	return nil
+}
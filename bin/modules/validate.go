@@ -0,0 +1,210 @@
+package modules
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/cunkz/goyummy/bin/config"
+	"github.com/cunkz/goyummy/bin/helpers/auth"
+	"github.com/cunkz/goyummy/bin/helpers/utils"
+)
+
+// validator coerces request bodies and enforces the field rules of a module
+type validator struct {
+	fields   []config.Field
+	patterns map[string]*regexp.Regexp
+}
+
+func newValidator(fields []config.Field) (*validator, error) {
+	v := &validator{fields: fields, patterns: map[string]*regexp.Regexp{}}
+	for _, f := range fields {
+		if f.Validate.Pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(f.Validate.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: invalid pattern: %w", f.Name, err)
+		}
+		v.patterns[f.Name] = re
+	}
+	return v, nil
+}
+
+// values returns the coerced body values, or every violation found.
+// Required rules only apply on create, updates are partial.
+func (v *validator) values(body map[string]any, create bool) (map[string]any, fieldErrors) {
+	values, coerceErrs := bodyValues(v.fields, body, create)
+
+	failed := map[string]string{}
+	for _, fe := range coerceErrs {
+		failed[fe.Field] = fe.Message
+	}
+
+	// report violations in field declaration order
+	var errs fieldErrors
+	for _, f := range v.fields {
+		if msg, ok := failed[f.Name]; ok {
+			errs = append(errs, fieldError{f.Name, msg})
+			continue
+		}
+		val, ok := values[f.Name]
+		if !ok {
+			if create && f.Validate.Required {
+				errs = append(errs, fieldError{f.Name, "is required"})
+			}
+			continue
+		}
+		if msg := v.check(f, val); msg != "" {
+			errs = append(errs, fieldError{f.Name, msg})
+		}
+	}
+
+	if len(errs) == 0 {
+		errs = hashValues(v.fields, values)
+	}
+	return values, errs
+}
+
+// hashValues replaces the values of hashed fields, after validation so
+// the rules apply to the plain value
+func hashValues(fields []config.Field, values map[string]any) fieldErrors {
+	var errs fieldErrors
+	for _, f := range fields {
+		s, ok := values[f.Name].(string)
+		if f.Hash == "" || !ok {
+			continue
+		}
+		h, err := auth.HashPassword(f.Hash, s)
+		if err != nil {
+			// bcrypt refuses more than 72 bytes
+			errs = append(errs, fieldError{f.Name, err.Error()})
+			continue
+		}
+		values[f.Name] = h
+	}
+	return errs
+}
+
+func (v *validator) check(f config.Field, val any) string {
+	r := f.Validate
+
+	if val == nil {
+		if r.Required {
+			return "is required"
+		}
+		return ""
+	}
+
+	if s, ok := val.(string); ok && f.Type == config.FieldString {
+		if r.Required && s == "" {
+			return "is required"
+		}
+		n := utf8.RuneCountInString(s)
+		if r.MinLength != nil && n < *r.MinLength {
+			return fmt.Sprintf("must be at least %d characters", *r.MinLength)
+		}
+		if r.MaxLength != nil && n > *r.MaxLength {
+			return fmt.Sprintf("must be at most %d characters", *r.MaxLength)
+		}
+	}
+
+	if (r.Min != nil || r.Max != nil) && isNumeric(f.Type) {
+		if n, ok := numberValue(val); ok {
+			if r.Min != nil && n.Cmp(ratOf(*r.Min)) < 0 {
+				return "must be greater than or equal to " + formatNumber(*r.Min)
+			}
+			if r.Max != nil && n.Cmp(ratOf(*r.Max)) > 0 {
+				return "must be less than or equal to " + formatNumber(*r.Max)
+			}
+		}
+	}
+
+	if re := v.patterns[f.Name]; re != nil && !re.MatchString(fmt.Sprint(val)) {
+		return "must match pattern " + r.Pattern
+	}
+
+	if len(r.Enum) > 0 {
+		// numbers by value, 10.50 is 10.5
+		s := fmt.Sprint(val)
+		n, numeric := numberValue(val)
+		numeric = numeric && isNumeric(f.Type)
+		allowed := make([]string, len(r.Enum))
+		found := false
+		for i, e := range r.Enum {
+			allowed[i] = fmt.Sprint(e)
+			if numeric {
+				en, ok := numberValue(e)
+				found = found || (ok && en.Cmp(n) == 0)
+			} else if allowed[i] == s {
+				found = true
+			}
+		}
+		if !found {
+			return "must be one of: " + strings.Join(allowed, ", ")
+		}
+	}
+
+	switch r.Format {
+	case config.FormatEmail:
+		s := fmt.Sprint(val)
+		if addr, err := mail.ParseAddress(s); err != nil || addr.Address != s {
+			return "must be a valid email"
+		}
+	case config.FormatURL:
+		u, err := url.ParseRequestURI(fmt.Sprint(val))
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return "must be a valid URL"
+		}
+	case config.FormatUUID:
+		if _, err := uuid.Parse(fmt.Sprint(val)); err != nil {
+			return "must be a valid UUID"
+		}
+	}
+
+	return ""
+}
+
+func isNumeric(fieldType string) bool {
+	return fieldType == config.FieldInt || fieldType == config.FieldFloat || fieldType == config.FieldDecimal
+}
+
+// numberValue reads a number as written, floats by their shortest decimal
+// so 0.1 is 1/10 whether it comes from JSON, a string or the recipe
+func numberValue(val any) (*big.Rat, bool) {
+	switch t := val.(type) {
+	case int:
+		return new(big.Rat).SetInt64(int64(t)), true
+	case int64:
+		return new(big.Rat).SetInt64(t), true
+	case float64:
+		return new(big.Rat).SetString(formatNumber(t))
+	case string:
+		return new(big.Rat).SetString(t)
+	case json.Number:
+		return new(big.Rat).SetString(t.String())
+	}
+	return nil, false
+}
+
+func ratOf(n float64) *big.Rat {
+	r, _ := new(big.Rat).SetString(formatNumber(n))
+	return r
+}
+
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+func responseInvalid(c *fiber.Ctx, errs fieldErrors) error {
+	return utils.ResponseErrorData(c, fiber.StatusUnprocessableEntity, errs, "Validation failed")
+}